@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffInfo 重连等待策略，时间单位为秒，为0时使用默认值
+type BackoffInfo struct {
+	Initial     int     `json:"initial" toml:"initial"`         // 第一次重连前的等待，默认3
+	Max         int     `json:"max" toml:"max"`                 // 最长等待，默认60
+	Multiplier  float64 `json:"multiplier" toml:"multiplier"`   // 每次失败后等待时长的倍数，默认2
+	Jitter      float64 `json:"jitter" toml:"jitter"`           // 随机抖动比例（0~1），默认0.2，小于0代表不抖动
+	MinRun      int     `json:"minRun" toml:"minRun"`           // 连接保持超过该时长后断开，等待从initial重新计算，默认30
+	AuthInitial int     `json:"authInitial" toml:"authInitial"` // 密码校验失败后第一次重连前的等待，默认60
+	AuthMax     int     `json:"authMax" toml:"authMax"`         // 密码校验失败后的最长等待，默认1800
+}
+
+// backoff 根据BackoffInfo计算每次重连前的等待时长
+// 密码校验失败与其他错误分开计算，避免错误的密码频繁请求服务端
+type backoff struct {
+	initial, max         time.Duration
+	authInitial, authMax time.Duration
+	multiplier, jitter   float64
+	minRun               time.Duration
+
+	cur, authCur time.Duration
+	rnd          *rand.Rand
+}
+
+func seconds(v, def int) time.Duration {
+	if v <= 0 {
+		v = def
+	}
+	return time.Duration(v) * time.Second
+}
+
+func newBackoff(info BackoffInfo) *backoff {
+	b := &backoff{
+		initial:     seconds(info.Initial, 3),
+		max:         seconds(info.Max, 60),
+		authInitial: seconds(info.AuthInitial, 60),
+		authMax:     seconds(info.AuthMax, 1800),
+		minRun:      seconds(info.MinRun, 30),
+		multiplier:  info.Multiplier,
+		jitter:      info.Jitter,
+		rnd:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if b.multiplier < 1 {
+		b.multiplier = 2
+	}
+	if b.jitter == 0 {
+		b.jitter = 0.2
+	} else if b.jitter < 0 {
+		b.jitter = 0
+	} else if b.jitter > 1 {
+		b.jitter = 1
+	}
+	if b.max < b.initial {
+		b.max = b.initial
+	}
+	if b.authMax < b.authInitial {
+		b.authMax = b.authInitial
+	}
+	return b
+}
+
+// next 连接失败或者断开后的等待时长
+func (b *backoff) next() time.Duration {
+	b.cur = b.grow(b.cur, b.initial, b.max)
+	return b.spread(b.cur)
+}
+
+// nextAuth 密码校验失败后的等待时长
+func (b *backoff) nextAuth() time.Duration {
+	b.authCur = b.grow(b.authCur, b.authInitial, b.authMax)
+	return b.spread(b.authCur)
+}
+
+// connected 连接成功，密码校验的等待重新计算
+func (b *backoff) connected() {
+	b.authCur = 0
+}
+
+// disconnected 连接断开，保持时间足够长时等待重新计算
+func (b *backoff) disconnected(run time.Duration) {
+	if run >= b.minRun {
+		b.cur = 0
+	}
+}
+
+func (b *backoff) grow(cur, initial, max time.Duration) time.Duration {
+	if cur <= 0 {
+		return initial
+	}
+	cur = time.Duration(float64(cur) * b.multiplier)
+	if cur > max {
+		cur = max
+	}
+	return cur
+}
+
+// spread 在[d*(1-jitter), d*(1+jitter)]之间随机取值，避免大量agent同时重连
+func (b *backoff) spread(d time.Duration) time.Duration {
+	if b.jitter <= 0 {
+		return d
+	}
+	delta := (b.rnd.Float64()*2 - 1) * b.jitter * float64(d)
+	return d + time.Duration(delta)
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewBackoff(t *testing.T) {
+	cases := []struct {
+		info        BackoffInfo
+		initial     time.Duration
+		max         time.Duration
+		authInitial time.Duration
+		authMax     time.Duration
+		multiplier  float64
+		jitter      float64
+	}{
+		{BackoffInfo{}, 3 * time.Second, 60 * time.Second, 60 * time.Second, 1800 * time.Second, 2, 0.2},
+		{BackoffInfo{Initial: 10, Max: 5, Multiplier: 0.5, Jitter: -1}, 10 * time.Second, 10 * time.Second, 60 * time.Second, 1800 * time.Second, 2, 0},
+		{BackoffInfo{Multiplier: 1.5, Jitter: 3, AuthInitial: 100, AuthMax: 10}, 3 * time.Second, 60 * time.Second, 100 * time.Second, 100 * time.Second, 1.5, 1},
+		{BackoffInfo{Initial: -1, Max: -1, Jitter: 0.5}, 3 * time.Second, 60 * time.Second, 60 * time.Second, 1800 * time.Second, 2, 0.5},
+	}
+
+	for index, c := range cases {
+		b := newBackoff(c.info)
+		if b.initial != c.initial || b.max != c.max || b.authInitial != c.authInitial || b.authMax != c.authMax ||
+			b.multiplier != c.multiplier || b.jitter != c.jitter {
+			t.Errorf("unexpected backoff, index=%v got=%+v", index, b)
+			return
+		}
+	}
+}
+
+func TestBackoffNext(t *testing.T) {
+	b := newBackoff(BackoffInfo{Initial: 1, Max: 5, Jitter: -1, AuthInitial: 10, AuthMax: 30, MinRun: 10})
+
+	// 普通错误与密码校验失败分开增长，都不超过上限
+	for index, want := range []int{1, 2, 4, 5, 5} {
+		if d := b.next(); d != time.Duration(want)*time.Second {
+			t.Errorf("unexpected next, index=%v got=%v", index, d)
+			return
+		}
+	}
+	for index, want := range []int{10, 20, 30, 30} {
+		if d := b.nextAuth(); d != time.Duration(want)*time.Second {
+			t.Errorf("unexpected nextAuth, index=%v got=%v", index, d)
+			return
+		}
+	}
+
+	// 连接保持时间不足minRun时继续增长，超过后重新计算
+	b.disconnected(time.Second * 9)
+	if d := b.next(); d != time.Second*5 {
+		t.Error("short run should not reset backoff", d)
+		return
+	}
+	b.disconnected(time.Second * 10)
+	if d := b.next(); d != time.Second {
+		t.Error("long run should reset backoff", d)
+		return
+	}
+
+	// 连接成功后密码校验的等待重新计算
+	b.connected()
+	if d := b.nextAuth(); d != time.Second*10 {
+		t.Error("connected should reset auth backoff", d)
+		return
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := newBackoff(BackoffInfo{Initial: 10, Max: 10, Jitter: 0.2})
+	for i := 0; i < 100; i++ {
+		d := b.next()
+		if d < time.Second*8 || d > time.Second*12 {
+			t.Error("jitter out of range", d)
+			return
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// netCounter 网络的使用统计，所有字段使用atomic读写
+// 64位字段放在最前面，保证32位平台上的对齐
+type netCounter struct {
+	Sends   int64 // 通过网络发出的字节数
+	Recvs   int64 // 从网络收到的字节数
+	Listens int32
+	Accepts int32
+	Dials   int32
+}
+
+// fill 将统计数据写入report
+func (c *netCounter) fill(r NodeReport) NodeReport {
+	r.Listens = atomic.LoadInt32(&c.Listens)
+	r.Accepts = atomic.LoadInt32(&c.Accepts)
+	r.Dials = atomic.LoadInt32(&c.Dials)
+	r.Sends = atomic.LoadInt64(&c.Sends)
+	r.Recvs = atomic.LoadInt64(&c.Recvs)
+	return r
+}
+
+// dialed 记录一次成功的拨号，并统计连接上的流量
+func (c *netCounter) dialed(conn net.Conn) net.Conn {
+	atomic.AddInt32(&c.Dials, 1)
+	return c.wrapConn(conn)
+}
+
+// listened 记录一次成功的监听，并统计accept的连接
+func (c *netCounter) listened(l net.Listener) net.Listener {
+	atomic.AddInt32(&c.Listens, 1)
+	return &countedListener{Listener: l, counter: c}
+}
+
+func (c *netCounter) wrapConn(conn net.Conn) net.Conn {
+	cc := &countedConn{Conn: conn, counter: c}
+	if d, ok := conn.(interface{ Dialer() string }); ok {
+		// 保留flex stream的Dialer方法，portproxy与quicktrust依赖它获取来源
+		return &countedDialerConn{countedConn: cc, dialer: d}
+	}
+	return cc
+}
+
+type countedConn struct {
+	net.Conn
+	counter *netCounter
+}
+
+func (c *countedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.counter.Recvs, int64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.counter.Sends, int64(n))
+	return n, err
+}
+
+type countedDialerConn struct {
+	*countedConn
+	dialer interface{ Dialer() string }
+}
+
+func (c *countedDialerConn) Dialer() string {
+	return c.dialer.Dialer()
+}
+
+type countedListener struct {
+	net.Listener
+	counter *netCounter
+}
+
+func (l *countedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&l.counter.Accepts, 1)
+	return l.counter.wrapConn(conn), nil
+}
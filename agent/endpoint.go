@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+)
+
+const probeTimeout = time.Second * 3
+
+// EndpointInfo 服务端地址配置
+type EndpointInfo struct {
+	Address  string  `json:"address" toml:"address"`   // 服务端地址
+	WsEnable bool    `json:"wsEnable" toml:"wsEnable"` // 是否为Websocket服务
+	Wss      bool    `json:"wss" toml:"wss"`           // 是否为wss协议
+	WsPath   string  `json:"wsPath" toml:"wsPath"`     // Websocket路径
+	WsHost   string  `json:"wsHost" toml:"wsHost"`     // websocket握手使用的Host，为空时使用agent的wsHost
+	TLS      TLSInfo `json:"tls" toml:"tls"`           // tcp与wss连接的TLS配置
+}
+
+// String 带协议前缀的地址，用于日志与状态报告
+func (ep EndpointInfo) String() string {
+	if ep.WsEnable {
+		if ep.Wss {
+			return "wss://" + ep.Address
+		}
+		return "ws://" + ep.Address
+	}
+	if ep.TLS.Enable {
+		return "tls://" + ep.Address
+	}
+	return "tcp://" + ep.Address
+}
+
+// GetEndpoints 返回配置的所有服务端，没有配置endpoints时使用address等字段
+func (agent *AgentInfo) GetEndpoints() []EndpointInfo {
+	if len(agent.Endpoints) > 0 {
+		eps := append([]EndpointInfo{}, agent.Endpoints...)
+		for i := range eps {
+			if eps[i].WsHost == "" {
+				eps[i].WsHost = agent.WsHost
+			}
+		}
+		return eps
+	}
+	return []EndpointInfo{{
+		Address:  agent.Address,
+		WsEnable: agent.WsEnable,
+		Wss:      agent.Wss,
+		WsPath:   agent.WsPath,
+		WsHost:   agent.WsHost,
+		TLS:      agent.TLS,
+	}}
+}
+
+// endpoint 运行时的服务端
+type endpoint struct {
+	name    string
+	address string
+	dial    dialFunc
+	connect ConnectFunc
+}
+
+func (agent *AgentInfo) getEndpoints() []endpoint {
+	macs, _ := getMacAddr()
+	mac := strings.Join(macs, " ")
+
+	var eps []endpoint
+	for _, info := range agent.GetEndpoints() {
+		eps = append(eps, endpoint{
+			name:    info.String(),
+			address: info.Address,
+			dial:    agent.upstreamDial(info),
+			connect: agent.connectFn(info, mac),
+		})
+	}
+	return eps
+}
+
+// connectEndpoints 依次尝试连接服务端，第一个连接成功的服务端成为当前服务端
+// 密码校验失败时不再尝试其它服务端，所有服务端使用同一个密码，换一个服务端同样会失败
+func (mnet *NetNode) connectEndpoints() (*node.Node, error) {
+	eps := mnet.endpoints
+	if len(eps) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	if mnet.preferLatency && len(eps) > 1 {
+		eps = probeEndpoints(eps)
+	}
+
+	var lastErr error
+	var errs []string
+	for _, ep := range eps {
+		n, err := ep.connect()
+		if err == nil {
+			mnet.setAddress(ep.name)
+			return n, nil
+		}
+		if isAuthError(err) {
+			log.Printf("[%v] endpoint '%v' auth failed, stop trying. err=%v\n", mnet.Type, ep.name, err)
+			return nil, err
+		}
+		if len(eps) > 1 {
+			log.Printf("[%v] endpoint '%v' failed, try next. err=%v\n", mnet.Type, ep.name, err)
+		}
+		lastErr = err
+		errs = append(errs, fmt.Sprintf("%v: %v", ep.name, err))
+	}
+
+	if len(eps) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("all endpoints failed. %v", strings.Join(errs, "; "))
+}
+
+// probeEndpoints 并发探测服务端的tcp连接耗时（配置了代理时包含代理的耗时），按延迟从低到高排序，探测失败的排在最后
+func probeEndpoints(eps []endpoint) []endpoint {
+	rtts := make([]time.Duration, len(eps))
+	var wg sync.WaitGroup
+	for i, ep := range eps {
+		wg.Add(1)
+		go func(i int, ep endpoint) {
+			defer wg.Done()
+			start := time.Now()
+			c, err := dialTimeout(ep.dial, "tcp", ep.address, probeTimeout)
+			if err != nil {
+				rtts[i] = -1
+				return
+			}
+			rtts[i] = time.Since(start)
+			c.Close()
+		}(i, ep)
+	}
+	wg.Wait()
+
+	index := make([]int, len(eps))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		ra, rb := rtts[index[a]], rtts[index[b]]
+		if ra < 0 || rb < 0 {
+			return rb < 0 && ra >= 0
+		}
+		return ra < rb
+	})
+
+	sorted := make([]endpoint, 0, len(eps))
+	var logs []string
+	for _, i := range index {
+		sorted = append(sorted, eps[i])
+		if rtts[i] < 0 {
+			logs = append(logs, eps[i].name+"=unreachable")
+		} else {
+			logs = append(logs, fmt.Sprintf("%v=%v", eps[i].name, rtts[i].Round(time.Millisecond)))
+		}
+	}
+	log.Printf("probe endpoints: %v\n", strings.Join(logs, " "))
+	return sorted
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+// testEndpoints 按results生成服务端，results为空的服务端连接成功，调用顺序记录在calls中
+func testEndpoints(app *switcher.Server, calls *[]string, results ...error) []endpoint {
+	var mut sync.Mutex
+	var eps []endpoint
+	for i, result := range results {
+		name := "tcp://ep" + string(rune('a'+i))
+		result := result
+		eps = append(eps, endpoint{
+			name:    name,
+			address: name,
+			connect: func() (*node.Node, error) {
+				mut.Lock()
+				*calls = append(*calls, name)
+				mut.Unlock()
+				if result != nil {
+					return nil, result
+				}
+				return connectSwitcher(app, "agent")
+			},
+		})
+	}
+	return eps
+}
+
+func TestConnectEndpointsFailover(t *testing.T) {
+	app := switcher.NewServer("pswd")
+	dialErr := errors.New("dial timeout")
+
+	var calls []string
+	mnet := &NetNode{Type: "flex", endpoints: testEndpoints(app, &calls, dialErr, dialErr, nil, nil)}
+	n, err := mnet.connectEndpoints()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n.Close()
+	if !reflect.DeepEqual(calls, []string{"tcp://epa", "tcp://epb", "tcp://epc"}) {
+		t.Error("unexpected order", calls)
+		return
+	}
+	if mnet.Report().Address != "tcp://epc" {
+		t.Error("address should be the connected endpoint", mnet.Report().Address)
+		return
+	}
+
+	// 全部失败时返回所有服务端的错误
+	calls = nil
+	mnet.endpoints = testEndpoints(app, &calls, dialErr, errors.New("refused"))
+	_, err = mnet.connectEndpoints()
+	if err == nil || !strings.Contains(err.Error(), "tcp://epa: dial timeout") || !strings.Contains(err.Error(), "tcp://epb: refused") {
+		t.Error("unexpected error", err)
+	}
+}
+
+// 密码校验失败时不再尝试后面的服务端
+func TestConnectEndpointsAuthError(t *testing.T) {
+	app := switcher.NewServer("pswd")
+	var calls []string
+	mnet := &NetNode{Type: "flex", endpoints: testEndpoints(app, &calls,
+		errors.New("dial timeout"),
+		errors.New("close by peer: invalid checksum detected"),
+		nil)}
+
+	_, err := mnet.connectEndpoints()
+	if !isAuthError(err) {
+		t.Error("expect auth error", err)
+		return
+	}
+	if !reflect.DeepEqual(calls, []string{"tcp://epa", "tcp://epb"}) {
+		t.Error("failover should stop at auth error", calls)
+	}
+}
+
+// delayDial 延迟后返回一个内存连接，delay小于0时返回错误
+func delayDial(delay time.Duration) dialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		if delay < 0 {
+			return nil, errors.New("unreachable")
+		}
+		time.Sleep(delay)
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+}
+
+func TestProbeEndpoints(t *testing.T) {
+	app := switcher.NewServer("pswd")
+	var calls []string
+	eps := testEndpoints(app, &calls, nil, nil, nil)
+	eps[0].dial = delayDial(-1)
+	eps[1].dial = delayDial(time.Millisecond * 200)
+	eps[2].dial = delayDial(0)
+
+	sorted := probeEndpoints(eps)
+	var names []string
+	for _, ep := range sorted {
+		names = append(names, ep.name)
+	}
+	if !reflect.DeepEqual(names, []string{"tcp://epc", "tcp://epb", "tcp://epa"}) {
+		t.Error("unexpected order", names)
+		return
+	}
+
+	// preferLatency时先连接延迟最低的服务端
+	mnet := &NetNode{Type: "flex", endpoints: eps, preferLatency: true}
+	n, err := mnet.connectEndpoints()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n.Close()
+	if !reflect.DeepEqual(calls, []string{"tcp://epc"}) {
+		t.Error("unexpected connect order", calls)
+	}
+}
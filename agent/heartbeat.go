@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+)
+
+var errHeartbeatTimeout = errors.New("heartbeat timeout")
+
+// HeartbeatInfo 与服务端之间的心跳配置，时间单位为秒，为0时使用默认值
+type HeartbeatInfo struct {
+	Interval int `json:"interval" toml:"interval"` // 心跳间隔，默认15，小于0代表关闭心跳
+	Timeout  int `json:"timeout" toml:"timeout"`   // 等待回应的时长，超时后断开并重连，默认10
+}
+
+// pongConn 记录服务端对心跳包的回应，同时截获服务端推送的消息
+// flex的node不处理心跳回应，需要在读取数据包时截获
+type pongConn struct {
+	*noticeConn
+	pongs chan struct{}
+}
+
+func newPongConn(pc packet.Conn) *pongConn {
+	return &pongConn{noticeConn: newNoticeConn(pc), pongs: make(chan struct{}, 1)}
+}
+
+func (pc *pongConn) ReadBuffer() (*packet.Buffer, error) {
+	pbuf, err := pc.noticeConn.ReadBuffer()
+	if err == nil && pbuf.Cmd() == packet.CmdAlive|packet.CmdACKFlag {
+		select {
+		case pc.pongs <- struct{}{}:
+		default:
+		}
+	}
+	return pbuf, err
+}
+
+// heartbeat 定时向服务端发送心跳包并记录往返时长，超时未回应时关闭node
+// 通过SetConnectFunc自定义的连接不支持心跳，直接返回
+func (mnet *NetNode) heartbeat(n *node.Node, stop <-chan struct{}) error {
+	pc, ok := n.Conn.(*pongConn)
+	if !ok || mnet.heartbeatInfo.Interval < 0 {
+		return nil
+	}
+	interval := seconds(mnet.heartbeatInfo.Interval, 15)
+	timeout := seconds(mnet.heartbeatInfo.Timeout, 10)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+
+		// 丢弃node自身心跳得到的回应
+		select {
+		case <-pc.pongs:
+		default:
+		}
+
+		pbuf := packet.NewBuffer(nil)
+		pbuf.SetCmd(packet.CmdAlive)
+		pbuf.SetSrc(n.GetIP(), 0)
+		pbuf.SetDist(node.SwitcherIP, 0)
+
+		start := time.Now()
+		written := make(chan error, 1)
+		go func() { written <- n.WriteBuffer(pbuf) }()
+
+		timer := time.NewTimer(timeout)
+		err := func() error {
+			for {
+				select {
+				case err := <-written:
+					if err != nil {
+						return err
+					}
+				case <-pc.pongs:
+					mnet.setRTT(time.Since(start))
+					return nil
+				case <-timer.C:
+					return errHeartbeatTimeout
+				case <-stop:
+					return nil
+				}
+			}
+		}()
+		timer.Stop()
+
+		if err != nil {
+			log.Printf("[%v] heartbeat failed, reconnect. err=%v\n", mnet.Type, err)
+			n.Close()
+			return err
+		}
+	}
+}
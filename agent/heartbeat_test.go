@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+)
+
+// 服务端延迟回应心跳时记录往返时长，不再回应时断开node
+func TestHeartbeat(t *testing.T) {
+	c1, c2 := packet.Pipe()
+	n := node.New(newPongConn(c1))
+	running := make(chan struct{})
+	go func() {
+		n.Run()
+		close(running)
+	}()
+	defer n.Close()
+
+	var reply int32 = 1
+	go func() {
+		for {
+			pbuf, err := c2.ReadBuffer()
+			if err != nil {
+				return
+			}
+			if pbuf.Cmd() != packet.CmdAlive || atomic.LoadInt32(&reply) == 0 {
+				continue
+			}
+			time.Sleep(time.Millisecond * 50)
+			pong := packet.NewBuffer(nil)
+			pong.SetCmd(packet.CmdAlive | packet.CmdACKFlag)
+			c2.WriteBuffer(pong)
+		}
+	}()
+
+	mnet := NewNetwork(AgentInfo{
+		Network:   "flex",
+		Address:   "localhost:2000",
+		Domain:    "agent",
+		Heartbeat: HeartbeatInfo{Interval: 1, Timeout: 1},
+	})
+	stop := make(chan struct{})
+	defer close(stop)
+	hbErr := make(chan error, 1)
+	go func() { hbErr <- mnet.heartbeat(n, stop) }()
+
+	for i := 0; i < 100 && mnet.RTT() == 0; i++ {
+		time.Sleep(time.Millisecond * 30)
+	}
+	if rtt := mnet.RTT(); rtt < time.Millisecond*50 || rtt > time.Second {
+		t.Error("unexpected rtt", rtt)
+		return
+	}
+
+	atomic.StoreInt32(&reply, 0)
+	select {
+	case err := <-hbErr:
+		if err != errHeartbeatTimeout {
+			t.Error("unexpected error", err)
+			return
+		}
+	case <-time.After(time.Second * 5):
+		t.Error("heartbeat timeout not detected")
+		return
+	}
+	select {
+	case <-running:
+	case <-time.After(time.Second):
+		t.Error("node should be closed after heartbeat timeout")
+	}
+}
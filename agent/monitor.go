@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/net-agent/remotework/utils"
+)
+
+const DefaultMonitorListen = "127.0.0.1:2081"
+
+// MonitorInfo 本地状态接口配置
+type MonitorInfo struct {
+	Enable   bool   `json:"enable" toml:"enable"`
+	Listen   string `json:"listen" toml:"listen"`     // 监听地址，默认为 127.0.0.1:2081
+	Username string `json:"username" toml:"username"` // 设置password后启用HTTP Basic Auth
+	Password string `json:"password" toml:"password"`
+}
+
+// ServeMonitor 启动本地状态接口，提供Prometheus指标（/metrics）与json格式的状态报告（/report/networks、/report/services）
+// ctx结束或者调用Close时关闭监听并返回
+func (hub *NetHub) ServeMonitor(ctx context.Context, info MonitorInfo) error {
+	if info.Listen == "" {
+		info.Listen = DefaultMonitorListen
+	}
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/metrics").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.writeMetrics(utils.NewMetricWriter(w))
+	})
+	r.Methods("GET").Path("/report/networks").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, nil, hub.reportNetworks(true))
+	})
+	r.Methods("GET").Path("/report/services").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, nil, hub.serviceReports())
+	})
+	if info.Password != "" {
+		if info.Username == "" {
+			info.Username = "admin"
+		}
+		r.Use(utils.BasicAuth(info.Username, info.Password))
+	}
+
+	l, err := net.Listen("tcp", info.Listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("[monitor] listen on '%v'\n", info.Listen)
+
+	// 关闭监听的同时断开保持中的连接
+	srv := &http.Server{Handler: r}
+	ctx = hub.withCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	err = srv.Serve(l)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// serviceReports 与ServiceReport相同，没有服务时返回空列表
+func (hub *NetHub) serviceReports() []ReportInfo {
+	reports, err := hub.ServiceReport()
+	if err != nil {
+		return []ReportInfo{}
+	}
+	return reports
+}
+
+func (hub *NetHub) writeMetrics(m *utils.MetricWriter) {
+	defer m.Flush()
+
+	// 同名的多个节点取汇总，保证每个网络只有一组指标
+	nets := hub.reportNetworks(false)
+	series := func(name, typ, help string, value func(r NodeReport) float64) {
+		m.Header(name, typ, help)
+		for _, r := range nets {
+			m.Sample(name, value(r), "network", r.Type, "domain", r.Domain)
+		}
+	}
+	series("remotework_agent_network_connected", "gauge", "Whether the network is connected to the server.", func(r NodeReport) float64 {
+		if r.Connected {
+			return 1
+		}
+		return 0
+	})
+	m.Header("remotework_agent_network_state", "gauge", "Current connection state of the network, 1 for the active state.")
+	for _, r := range nets {
+		for _, state := range AllNodeStates {
+			value := 0.0
+			if r.State == state {
+				value = 1
+			}
+			m.Sample("remotework_agent_network_state", value, "network", r.Type, "domain", r.Domain, "state", string(state))
+		}
+	}
+	series("remotework_agent_network_alive_seconds", "gauge", "Seconds since the network started.", func(r NodeReport) float64 {
+		return r.Alive.Seconds()
+	})
+	series("remotework_agent_network_rtt_seconds", "gauge", "Round-trip time of the latest heartbeat, 0 if not measured.", func(r NodeReport) float64 {
+		return r.RTT.Seconds()
+	})
+	series("remotework_agent_network_reconnects_total", "counter", "Number of successful reconnects.", func(r NodeReport) float64 {
+		return float64(r.Reconnects)
+	})
+	series("remotework_agent_network_dials_total", "counter", "Number of dials on the network.", func(r NodeReport) float64 {
+		return float64(r.Dials)
+	})
+	series("remotework_agent_network_listens_total", "counter", "Number of listens on the network.", func(r NodeReport) float64 {
+		return float64(r.Listens)
+	})
+	series("remotework_agent_network_accepts_total", "counter", "Number of accepted connections on the network.", func(r NodeReport) float64 {
+		return float64(r.Accepts)
+	})
+	m.Header("remotework_agent_network_bytes_total", "counter", "Bytes transferred on the network.")
+	for _, r := range nets {
+		m.Sample("remotework_agent_network_bytes_total", float64(r.Sends), "network", r.Type, "domain", r.Domain, "direction", "send")
+		m.Sample("remotework_agent_network_bytes_total", float64(r.Recvs), "network", r.Type, "domain", r.Domain, "direction", "recv")
+	}
+
+	// 未命名的服务使用相同的默认名称，以服务的序号区分
+	svcs := hub.serviceReports()
+	m.Header("remotework_agent_service_actives", "gauge", "Number of active connections of the service.")
+	for i, r := range svcs {
+		m.Sample("remotework_agent_service_actives", float64(r.Actives), "service", r.Name, "index", strconv.Itoa(i))
+	}
+	m.Header("remotework_agent_service_dones_total", "counter", "Number of finished connections of the service.")
+	for i, r := range svcs {
+		m.Sample("remotework_agent_service_dones_total", float64(r.Dones), "service", r.Name, "index", strconv.Itoa(i))
+	}
+	m.Header("remotework_agent_service_dial_errors_total", "counter", "Number of failed dials to the service target.")
+	for i, r := range svcs {
+		m.Sample("remotework_agent_service_dial_errors_total", float64(r.DialErrors), "service", r.Name, "index", strconv.Itoa(i))
+	}
+	m.Header("remotework_agent_service_bytes_total", "counter", "Bytes transferred by the service.")
+	for i, r := range svcs {
+		m.Sample("remotework_agent_service_bytes_total", float64(r.Sends), "service", r.Name, "index", strconv.Itoa(i), "direction", "send")
+		m.Sample("remotework_agent_service_bytes_total", float64(r.Recvs), "service", r.Name, "index", strconv.Itoa(i), "direction", "recv")
+	}
+}
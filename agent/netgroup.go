@@ -0,0 +1,329 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/net-agent/flex/v2/node"
+)
+
+// netGroup 同一个网络名称下的多个NetNode，通常是同一个domain同时连接多个服务端
+// Dial优先使用在线且延迟低的节点，Listen在所有在线的节点上监听，节点上线后自动补充监听
+type netGroup struct {
+	name    string
+	members []*NetNode
+	lsns    map[*groupListener]struct{}
+	routes  map[string]*NetNode // 上次拨号成功的节点，对端不一定连接了所有服务端
+	mut     sync.Mutex
+
+	eventBus // 转发所有节点的状态事件
+}
+
+func newNetGroup(name string) *netGroup {
+	return &netGroup{
+		name:   name,
+		lsns:   make(map[*groupListener]struct{}),
+		routes: make(map[string]*NetNode),
+	}
+}
+
+// add 增加节点，返回取消订阅节点状态的函数
+func (g *netGroup) add(mnet *NetNode) func() {
+	g.mut.Lock()
+	g.members = append(g.members, mnet)
+	g.mut.Unlock()
+
+	ch, cancel := mnet.Subscribe()
+	go g.watch(mnet, ch)
+	return cancel
+}
+
+// watch 节点上线后在该节点上补充监听
+func (g *netGroup) watch(mnet *NetNode, ch <-chan StateEvent) {
+	for ev := range ch {
+		if ev.To == StateOnline {
+			for _, l := range g.listeners() {
+				if err := l.attach(mnet); err != nil {
+					log.Printf("[%v] listen '%v' on '%v' failed. err=%v\n", g.name, l.addr, mnet.Domain, err)
+				}
+			}
+		}
+		g.publish(ev)
+	}
+}
+
+func (g *netGroup) nodes() []*NetNode {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return append([]*NetNode{}, g.members...)
+}
+
+func (g *netGroup) listeners() []*groupListener {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	lsns := make([]*groupListener, 0, len(g.lsns))
+	for l := range g.lsns {
+		lsns = append(lsns, l)
+	}
+	return lsns
+}
+
+// healthy 在线的节点，按心跳延迟从低到高排序，没有测量延迟的排在最后
+// 只读取状态与延迟，不能等待正在重连的节点
+func (g *netGroup) healthy() []*NetNode {
+	type candidate struct {
+		mnet *NetNode
+		rtt  int64
+	}
+	var cands []candidate
+	for _, mnet := range g.nodes() {
+		if state, _, _ := mnet.State(); state == StateOnline {
+			cands = append(cands, candidate{mnet, int64(mnet.RTT())})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i].rtt, cands[j].rtt
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+
+	mnets := make([]*NetNode, 0, len(cands))
+	for _, c := range cands {
+		mnets = append(mnets, c.mnet)
+	}
+	return mnets
+}
+
+// Dial 依次尝试在线的节点，全部离线时与单个节点的行为一致
+// 对端不在某个服务端上时，需要等待拨号超时才会尝试下一个节点，所以优先使用上次成功的节点
+func (g *netGroup) Dial(network, addr string) (net.Conn, error) {
+	mnets := g.healthy()
+	if len(mnets) == 0 {
+		mnets = g.nodes()
+		if len(mnets) == 0 {
+			return nil, fmt.Errorf("network '%v' has no nodes", g.name)
+		}
+		mnets = mnets[:1]
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	g.mut.Lock()
+	last := g.routes[host]
+	g.mut.Unlock()
+	for i, mnet := range mnets {
+		if mnet == last {
+			copy(mnets[1:i+1], mnets[:i])
+			mnets[0] = last
+			break
+		}
+	}
+
+	var lastErr error
+	var errs []string
+	for _, mnet := range mnets {
+		conn, err := mnet.Dial(network, addr)
+		if err == nil {
+			g.mut.Lock()
+			g.routes[host] = mnet
+			g.mut.Unlock()
+			return conn, nil
+		}
+		lastErr = err
+		errs = append(errs, fmt.Sprintf("%v: %v", mnet.Report().Address, err))
+	}
+	if len(errs) == 1 {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("dial failed on all nodes. %v", strings.Join(errs, "; "))
+}
+
+// Listen 在所有在线的节点上监听，没有节点在线时也会成功，等待节点上线
+// 单个节点监听失败时只记录日志，该节点重新上线后会再次尝试
+func (g *netGroup) Listen(network, addr string) (net.Listener, error) {
+	l := &groupListener{
+		group:   g,
+		network: network,
+		addr:    addr,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+		lsns:    make(map[*NetNode]*memberListener),
+	}
+
+	g.mut.Lock()
+	g.lsns[l] = struct{}{}
+	g.mut.Unlock()
+
+	for _, mnet := range g.healthy() {
+		if err := l.attach(mnet); err != nil {
+			log.Printf("[%v] listen '%v' on '%v' failed. err=%v\n", g.name, addr, mnet.Domain, err)
+		}
+	}
+	return l, nil
+}
+
+// Report 汇总所有节点，状态取最好的节点，计数取总和
+func (g *netGroup) Report() NodeReport {
+	reports := g.Reports()
+	if len(reports) == 0 {
+		return NodeReport{Type: g.name, State: StateDisconnected}
+	}
+	best := 0
+	for i, r := range reports {
+		if stateRank(r.State) > stateRank(reports[best].State) {
+			best = i
+		}
+	}
+
+	info := reports[best]
+	info.Reconnects, info.Listens, info.Accepts, info.Dials = 0, 0, 0, 0
+	info.Sends, info.Recvs = 0, 0
+	for _, r := range reports {
+		info.Connected = info.Connected || r.Connected
+		info.Reconnects += r.Reconnects
+		info.Listens += r.Listens
+		info.Accepts += r.Accepts
+		info.Dials += r.Dials
+		info.Sends += r.Sends
+		info.Recvs += r.Recvs
+	}
+	return info
+}
+
+// Reports 每个节点的状态
+func (g *netGroup) Reports() []NodeReport {
+	var reports []NodeReport
+	for _, mnet := range g.nodes() {
+		reports = append(reports, mnet.Report())
+	}
+	return reports
+}
+
+func stateRank(state NodeState) int {
+	switch state {
+	case StateOnline:
+		return 3
+	case StateConnecting:
+		return 2
+	case StateBackoff:
+		return 1
+	}
+	return 0
+}
+
+// groupListener 合并多个节点上的监听
+// 节点断开后对应的监听失效，但不影响其它节点，也不会返回错误
+type groupListener struct {
+	group   *netGroup
+	network string
+	addr    string
+	conns   chan net.Conn
+	done    chan struct{}
+	once    sync.Once
+	lsns    map[*NetNode]*memberListener
+	mut     sync.Mutex
+}
+
+type memberListener struct {
+	net.Listener
+	node *node.Node // 监听所在的连接，重连后需要重新监听
+}
+
+// attach 在节点当前的连接上监听，已经监听过时直接返回
+func (l *groupListener) attach(mnet *NetNode) error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	select {
+	case <-l.done:
+		return nil
+	default:
+	}
+
+	n, err := mnet.GetNode()
+	if err != nil {
+		return err
+	}
+	old := l.lsns[mnet]
+	if old != nil && old.node == n {
+		return nil
+	}
+
+	lsn, err := mnet.Listen(l.network, l.addr)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		old.Close()
+	}
+	ml := &memberListener{Listener: lsn, node: n}
+	l.lsns[mnet] = ml
+	go l.serve(mnet, ml)
+	return nil
+}
+
+func (l *groupListener) serve(mnet *NetNode, ml *memberListener) {
+	for {
+		conn, err := ml.Accept()
+		if err != nil {
+			l.mut.Lock()
+			if l.lsns[mnet] == ml {
+				delete(l.lsns, mnet)
+			}
+			l.mut.Unlock()
+			return
+		}
+
+		select {
+		case l.conns <- conn:
+		case <-l.done:
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (l *groupListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *groupListener) Close() error {
+	l.once.Do(func() {
+		l.group.mut.Lock()
+		delete(l.group.lsns, l)
+		l.group.mut.Unlock()
+
+		l.mut.Lock()
+		close(l.done)
+		for _, ml := range l.lsns {
+			ml.Close()
+		}
+		l.lsns = nil
+		l.mut.Unlock()
+	})
+	return nil
+}
+
+func (l *groupListener) Addr() net.Addr {
+	return netAddr{l.network, l.addr}
+}
+
+// netAddr 虚拟网络上的地址
+type netAddr struct {
+	network string
+	addr    string
+}
+
+func (a netAddr) Network() string { return a.network }
+func (a netAddr) String() string  { return a.addr }
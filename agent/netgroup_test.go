@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+// connectSwitcher 通过内存连接接入switcher
+func connectSwitcher(app *switcher.Server, domain string) (*node.Node, error) {
+	c1, c2 := packet.Pipe()
+	go app.ServeConn(c2)
+	n, err := switcher.UpgradeToNode(c1, domain, "mac-"+domain, "pswd")
+	if err != nil {
+		return nil, err
+	}
+	n.SetDomain(domain)
+	return n, nil
+}
+
+func waitState(mnet *NetNode, state NodeState) bool {
+	for i := 0; i < 100; i++ {
+		if s, _, _ := mnet.State(); s == state {
+			return true
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return false
+}
+
+// 一个服务端不可达时，通过另一个服务端的拨号与监听不受影响
+func TestNetGroupUnreachableMember(t *testing.T) {
+	app := switcher.NewServer("pswd")
+
+	peer, err := connectSwitcher(app, "peer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go peer.Run()
+	defer peer.Close()
+	l, err := peer.Listen(80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	info := AgentInfo{Network: "flex", Address: "localhost:2000", Domain: "agent", Heartbeat: HeartbeatInfo{Interval: -1}}
+	online := NewNetwork(info)
+	online.SetConnectFunc(func() (*node.Node, error) { return connectSwitcher(app, "agent") })
+
+	// 连接一直等到超时，期间持有nodeMut
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	unreachable := NewNetwork(info)
+	unreachable.SetConnectFunc(func() (*node.Node, error) {
+		select {
+		case <-time.After(time.Second * 5):
+		case <-ctx.Done():
+		}
+		return nil, errors.New("dial timeout")
+	})
+
+	g := newNetGroup("flex")
+	defer g.add(online)()
+	defer g.add(unreachable)()
+	go online.KeepAlive(ctx)
+	go unreachable.KeepAlive(ctx)
+	if !waitState(online, StateOnline) || !waitState(unreachable, StateConnecting) {
+		t.Error("unexpected node states")
+		return
+	}
+
+	start := time.Now()
+	if r := g.Report(); r.State != StateOnline {
+		t.Error("group should be online", r.State)
+		return
+	}
+
+	conn, err := g.Dial("flex", "peer:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("ping")); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Error("echo failed", err)
+		return
+	}
+
+	gl, err := g.Listen("flex", "agent:81")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer gl.Close()
+
+	if dur := time.Since(start); dur > time.Second {
+		t.Error("group blocked by unreachable member", dur)
+	}
+}
+
+// 节点全部移除后拨号返回错误，不会panic
+func TestNetGroupEmpty(t *testing.T) {
+	g := newNetGroup("flex")
+	remove := g.add(NewNetwork(AgentInfo{Network: "flex", Address: "localhost:2000", Domain: "agent"}))
+	remove()
+
+	if _, err := g.Dial("flex", "peer:80"); err == nil {
+		t.Error("dial on empty group should fail")
+	}
+	if r := g.Report(); r.State != StateDisconnected || r.Connected {
+		t.Errorf("unexpected report: %+v", r)
+	}
+}
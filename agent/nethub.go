@@ -1,12 +1,14 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/url"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,45 +16,37 @@ import (
 	"github.com/olekukonko/tablewriter"
 )
 
-// tcp network wrap
-type tcpnetwork struct {
-	Type    string
-	Listens int32
-	Dials   int32
-}
-
-func (tcp *tcpnetwork) Dial(network, addr string) (net.Conn, error) {
-	return net.Dial(network, addr)
-}
-func (tcp *tcpnetwork) Listen(network, addr string) (net.Listener, error) {
-	return net.Listen(network, addr)
-}
-func (tcp *tcpnetwork) Report() NodeReport {
-	return NodeReport{
-		Type: tcp.Type,
-	}
-}
-
 type NetHub struct {
-	nets map[string]Network
-	mut  sync.RWMutex
+	nets    map[string]Network
+	unwatch map[string]func() // 取消订阅网络的状态事件
+	mut     sync.RWMutex
 
 	svcs      []Service
+	svcMut    sync.RWMutex
 	svcWaiter sync.WaitGroup
+
+	eventBus // 汇总所有网络的状态事件
+
+	cancels   []func() // Close时依次调用，停止服务、网络与事件订阅
+	netWaiter sync.WaitGroup
+	started   bool
+	closed    bool
+	closeMut  sync.Mutex
 }
 
+// NewNetHub 默认包含tcp、tcp4、tcp6网络，其它注册的scheme在第一次使用时创建
 func NewNetHub() *NetHub {
 	nets := make(map[string]Network)
-	nets["tcp"] = &tcpnetwork{"tcp", 0, 0}
-	nets["tcp4"] = &tcpnetwork{"tcp4", 0, 0}
-	nets["tcp6"] = &tcpnetwork{"tcp6", 0, 0}
+	for _, name := range []string{"tcp", "tcp4", "tcp6"} {
+		nets[name], _ = NewSchemeNetwork(NetworkInfo{Name: name})
+	}
 
-	return &NetHub{nets: nets}
+	return &NetHub{nets: nets, unwatch: make(map[string]func())}
 }
 
 func (hub *NetHub) TriggerNetworkUpdate(network string) {
 	log.Printf("[hub] network='%v' updated.\n", network)
-	for _, svc := range hub.svcs {
+	for _, svc := range hub.services() {
 		if svc.Network() == network {
 			go svc.Update()
 		}
@@ -67,17 +61,39 @@ func (hub *NetHub) AddServices(svcs ...Service) {
 			continue
 		}
 
+		hub.svcMut.Lock()
 		hub.svcs = append(hub.svcs, svc)
+		hub.svcMut.Unlock()
+
+		// 网络可能在Init之后、加入列表之前上线，此时不会收到更新
+		if svc.Report().State == ServiceWaiting {
+			if online, _ := hub.NetworkOnline(svc.Network()); online {
+				go svc.Update()
+			}
+		}
 	}
 }
 
-func (hub *NetHub) StartServices() {
-	for _, svc := range hub.svcs {
+func (hub *NetHub) services() []Service {
+	hub.svcMut.RLock()
+	defer hub.svcMut.RUnlock()
+	return hub.svcs
+}
+
+// StartServices 启动所有服务，ctx结束或者调用Close时停止
+func (hub *NetHub) StartServices(ctx context.Context) {
+	ctx = hub.withCancel(ctx)
+
+	hub.closeMut.Lock()
+	hub.started = true
+	hub.closeMut.Unlock()
+
+	for _, svc := range hub.services() {
 		hub.svcWaiter.Add(1)
 		log.Printf("[hub] service running. name='%v'\n", svc.Name())
 		go func(svc Service) {
 			defer hub.svcWaiter.Done()
-			err := svc.Start()
+			err := svc.Start(ctx)
 			<-time.After(time.Millisecond * 100)
 			log.Printf("[hub] service stopped. name='%v' err=%v\n", svc.Name(), err)
 		}(svc)
@@ -85,7 +101,7 @@ func (hub *NetHub) StartServices() {
 }
 
 func (hub *NetHub) ServicesRange(fn func(svc Service)) {
-	for _, svc := range hub.svcs {
+	for _, svc := range hub.services() {
 		fn(svc)
 	}
 }
@@ -94,13 +110,70 @@ func (hub *NetHub) Wait() {
 	hub.svcWaiter.Wait()
 }
 
+// KeepAlive 在后台保持网络与服务端的连接，ctx结束或者调用Close时断开
+func (hub *NetHub) KeepAlive(ctx context.Context, mnet *NetNode) {
+	ctx = hub.withCancel(ctx)
+	hub.netWaiter.Add(1)
+	go func() {
+		defer hub.netWaiter.Done()
+		mnet.KeepAlive(ctx)
+	}()
+}
+
+// Close 停止所有服务并断开所有网络，等待服务与网络退出后返回
+func (hub *NetHub) Close() error {
+	hub.closeMut.Lock()
+	if hub.closed {
+		hub.closeMut.Unlock()
+		return nil
+	}
+	hub.closed = true
+	cancels := hub.cancels
+	hub.cancels = nil
+	started := hub.started
+	hub.closeMut.Unlock()
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	if !started {
+		// 服务没有启动时，Init打开的监听需要直接关闭
+		for _, svc := range hub.services() {
+			svc.Close()
+		}
+	}
+
+	hub.svcWaiter.Wait()
+	hub.netWaiter.Wait()
+	log.Println("[hub] closed.")
+	return nil
+}
+
+// withCancel 返回可以被Close取消的ctx，hub已经关闭时返回已取消的ctx
+func (hub *NetHub) withCancel(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	hub.addCancel(cancel)
+	return ctx
+}
+
+func (hub *NetHub) addCancel(cancel func()) {
+	hub.closeMut.Lock()
+	defer hub.closeMut.Unlock()
+	if hub.closed {
+		cancel()
+		return
+	}
+	hub.cancels = append(hub.cancels, cancel)
+}
+
 func (hub *NetHub) ServiceReport() ([]ReportInfo, error) {
-	if len(hub.svcs) <= 0 {
+	svcs := hub.services()
+	if len(svcs) <= 0 {
 		return nil, errors.New("NO SERVICES")
 	}
 
 	var reports []ReportInfo
-	for _, svc := range hub.svcs {
+	for _, svc := range svcs {
 		reports = append(reports, svc.Report())
 	}
 	return reports, nil
@@ -113,7 +186,7 @@ func (hub *NetHub) ServiceReportAscii(out *os.File) {
 	}
 
 	table := tablewriter.NewWriter(out)
-	table.SetHeader([]string{"index", "name", "state", "listen", "target", "actives", "dones"})
+	table.SetHeader([]string{"index", "name", "state", "listen", "target", "actives", "dones", "sends", "recvs"})
 	for index, info := range reports {
 		table.Append([]string{
 			fmt.Sprintf("%v", index),
@@ -123,21 +196,38 @@ func (hub *NetHub) ServiceReportAscii(out *os.File) {
 			info.Target,
 			fmt.Sprintf("%v", info.Actives),
 			fmt.Sprintf("%v", info.Dones),
+			fmt.Sprintf("%v", info.Sends),
+			fmt.Sprintf("%v", info.Recvs),
 		})
 	}
 	table.Render()
 }
 
 func (hub *NetHub) NetworkReport() ([]NodeReport, error) {
-	if len(hub.nets) <= 0 {
+	reports := hub.reportNetworks(true)
+	if len(reports) <= 0 {
 		return nil, errors.New("NO NETWORKS")
 	}
+	return reports, nil
+}
 
-	var reports []NodeReport
+// reportNetworks 各网络的状态，expand为true时同名的多个节点分别列出，否则取汇总
+func (hub *NetHub) reportNetworks(expand bool) []NodeReport {
+	hub.mut.RLock()
+	defer hub.mut.RUnlock()
+
+	reports := []NodeReport{}
 	for _, nt := range hub.nets {
+		if g, ok := nt.(*netGroup); ok && expand {
+			reports = append(reports, g.Reports()...)
+			continue
+		}
 		reports = append(reports, nt.Report())
 	}
-	return reports, nil
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Type < reports[j].Type
+	})
+	return reports
 }
 
 func (hub *NetHub) NetworkReportAscii(out *os.File) {
@@ -148,21 +238,27 @@ func (hub *NetHub) NetworkReportAscii(out *os.File) {
 	}
 
 	table := tablewriter.NewWriter(out)
-	table.SetHeader([]string{"index", "type", "addr", "domain", "lsn", "dial"})
+	table.SetHeader([]string{"index", "type", "addr", "domain", "state", "rtt", "lsn", "accept", "dial", "sends", "recvs"})
 	for index, info := range reports {
 		table.Append([]string{
 			fmt.Sprintf("%v", index),
 			info.Type,
 			info.Address,
 			info.Domain,
+			string(info.State),
+			fmt.Sprintf("%v", info.RTT.Round(time.Millisecond)),
 			fmt.Sprintf("%v", info.Listens),
+			fmt.Sprintf("%v", info.Accepts),
 			fmt.Sprintf("%v", info.Dials),
+			fmt.Sprintf("%v", info.Sends),
+			fmt.Sprintf("%v", info.Recvs),
 		})
 	}
 	table.Render()
 }
 
 // AddNetwork 在hub中增加network
+// 同一个名称可以添加多个NetNode（例如连接不同的服务端），此时合并为一组，需要在服务初始化之前添加
 func (hub *NetHub) AddNetwork(network string, mnet Network) error {
 	if network == "" {
 		return errors.New("invalid network name=''")
@@ -170,29 +266,114 @@ func (hub *NetHub) AddNetwork(network string, mnet Network) error {
 	hub.mut.Lock()
 	defer hub.mut.Unlock()
 
-	_, found := hub.nets[network]
+	existing, found := hub.nets[network]
 	if found {
-		return errors.New("network exists")
+		return hub.joinNetwork(network, existing, mnet)
 	}
 	hub.nets[network] = mnet
+	hub.watch(network, mnet, true)
 	return nil
 }
 
-// GetNetwork 获取网络
+// joinNetwork 将NetNode加入同名的网络，第二个节点加入时把已有的节点转换为netGroup
+func (hub *NetHub) joinNetwork(network string, existing, mnet Network) error {
+	n, ok := mnet.(*NetNode)
+	if !ok {
+		return errors.New("network exists")
+	}
+
+	switch e := existing.(type) {
+	case *netGroup:
+		hub.addCancel(e.add(n))
+		return nil
+	case *NetNode:
+		if cancel, found := hub.unwatch[network]; found {
+			cancel()
+		}
+		g := newNetGroup(network)
+		hub.addCancel(g.add(e))
+		hub.addCancel(g.add(n))
+		hub.nets[network] = g
+		// netGroup上线后自行补充监听，不需要触发服务更新
+		hub.watch(network, g, false)
+		return nil
+	}
+	return errors.New("network exists")
+}
+
+// watch 订阅网络的状态事件，调用时需要持有hub.mut
+func (hub *NetHub) watch(network string, mnet Network, update bool) {
+	n, ok := mnet.(interface {
+		Subscribe() (<-chan StateEvent, func())
+	})
+	if !ok {
+		return
+	}
+	ch, cancel := n.Subscribe()
+	hub.unwatch[network] = cancel
+	hub.addCancel(cancel)
+	go hub.watchNetwork(network, ch, update)
+}
+
+// watchNetwork 转发网络的状态事件，update为true时上线触发服务更新
+func (hub *NetHub) watchNetwork(network string, ch <-chan StateEvent, update bool) {
+	for ev := range ch {
+		hub.publish(ev)
+		if update && ev.To == StateOnline {
+			hub.TriggerNetworkUpdate(network)
+		}
+	}
+}
+
+// GetNetwork 获取网络，没有添加过但注册了同名scheme时自动创建
 func (hub *NetHub) GetNetwork(network string) (Network, error) {
 	if network == "" {
 		return nil, errors.New("invalid network name=''")
 	}
 	hub.mut.RLock()
-	defer hub.mut.RUnlock()
-
 	mnet, found := hub.nets[network]
-	if !found {
+	hub.mut.RUnlock()
+	if found {
+		return mnet, nil
+	}
+	return hub.resolveScheme(network)
+}
+
+// resolveScheme 使用同名的scheme创建网络并加入hub
+func (hub *NetHub) resolveScheme(network string) (Network, error) {
+	hub.mut.Lock()
+	defer hub.mut.Unlock()
+
+	if mnet, found := hub.nets[network]; found {
+		return mnet, nil
+	}
+	mnet, err := NewSchemeNetwork(NetworkInfo{Name: network})
+	if err != nil {
 		return nil, fmt.Errorf("network='%v' not found", network)
 	}
+	hub.nets[network] = mnet
+	hub.watch(network, mnet, true)
 	return mnet, nil
 }
 
+// AddScheme 使用注册的scheme创建网络，以info.Name加入hub
+func (hub *NetHub) AddScheme(info NetworkInfo) error {
+	mnet, err := NewSchemeNetwork(info)
+	if err != nil {
+		return err
+	}
+	return hub.AddNetwork(info.Name, mnet)
+}
+
+// NetworkOnline 判断网络是否已经连接到服务端
+func (hub *NetHub) NetworkOnline(network string) (bool, error) {
+	mnet, err := hub.GetNetwork(network)
+	if err != nil {
+		return false, err
+	}
+	return mnet.Report().State == StateOnline, nil
+}
+
 // Dial 创建连接
 func (hub *NetHub) Dial(network, addr string) (net.Conn, error) {
 	mnet, err := hub.GetNetwork(network)
@@ -225,10 +406,10 @@ func (hub *NetHub) DialURL(raw string) (net.Conn, error) {
 
 // dialu 根据url.URL对象信息创建连接
 // - url.Scheme 对应 network
-// - url.Host 对应 address
+// - url.Host 对应 address，为空时取url.Path（unix:///run/remotework.sock）
 // - url.Query 对应其它控制参数，例如：加密、压缩等
 func (hub *NetHub) dialu(u *url.URL) (net.Conn, error) {
-	c, err := hub.Dial(u.Scheme, u.Host)
+	c, err := hub.Dial(u.Scheme, urlAddr(u))
 	if err != nil {
 		return nil, err
 	}
@@ -264,7 +445,7 @@ func ListenURL(network interface {
 		return nil, err
 	}
 
-	l, err := network.Listen(u.Scheme, u.Host)
+	l, err := network.Listen(u.Scheme, urlAddr(u))
 	if err != nil {
 		return nil, err
 	}
@@ -277,6 +458,14 @@ func ListenURL(network interface {
 	return newSecretListener(l, secret), nil
 }
 
+// urlAddr URL中的地址，unix等以路径为地址的网络没有Host
+func urlAddr(u *url.URL) string {
+	if u.Host == "" {
+		return u.Path
+	}
+	return u.Host
+}
+
 //
 //
 // Listener
@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/net-agent/flex/v2/node"
@@ -18,67 +20,151 @@ type Network interface {
 	Report() NodeReport
 }
 type NodeReport struct {
-	Type    string
-	Address string
-	Domain  string
-	Alive   time.Duration
-	Listens int32
-	Accepts int32
-	Dials   int32
-	Sends   int64
-	Recvs   int64
+	Type       string        `json:"type"`
+	Address    string        `json:"address"`
+	Domain     string        `json:"domain"`
+	Alive      time.Duration `json:"alive"`
+	Connected  bool          `json:"connected"`  // 是否已经连接到服务端
+	Reconnects int32         `json:"reconnects"` // 重连成功的次数
+	State      NodeState     `json:"state"`
+	StateTime  time.Duration `json:"stateTime"` // 进入当前状态的时长
+	LastError  string        `json:"lastError"` // 最近一次连接失败或断开的原因
+	Listens    int32         `json:"listens"`
+	Accepts    int32         `json:"accepts"`
+	Dials      int32         `json:"dials"`
+	Sends      int64         `json:"sends"`
+	Recvs      int64         `json:"recvs"`
+
+	RTT time.Duration `json:"rtt"` // 最近一次心跳的往返时长，未连接或者还没有测量时为0
 }
 type NetNode struct {
+	netCounter
+
 	connectFn ConnectFunc
 	node      *node.Node
 	nodeMut   sync.RWMutex
+	closed    bool // KeepAlive退出后不再连接服务端
+
+	endpoints     []endpoint
+	preferLatency bool // 连接前按延迟排序服务端
+	backoff       BackoffInfo
+	heartbeatInfo HeartbeatInfo
 
 	Type      string
 	Address   string
 	Domain    string
 	StartTime time.Time
-	Listens   int32
-	Accepts   int32
-	Dials     int32
-	Sends     int64
-	Recvs     int64
+
+	Reconnects int32
+	connected  int32 // node不为空时为1，Report不需要等待nodeMut（连接过程中会一直持有）
+
+	eventBus
+	state     NodeState
+	stateTime time.Time
+	lastErr   error
+	rtt       time.Duration
+	stateMut  sync.RWMutex
 }
 type ConnectFunc func() (*node.Node, error)
 
 func NewNetwork(info AgentInfo) *NetNode {
 	n := &NetNode{
-		connectFn: info.GetConnectFn(),
+		endpoints:     info.getEndpoints(),
+		preferLatency: info.PreferLatency,
+		backoff:       info.Backoff,
+		heartbeatInfo: info.Heartbeat,
 
 		Type:      info.Network,
 		Domain:    info.Domain,
 		StartTime: time.Now(),
+		state:     StateDisconnected,
+		stateTime: time.Now(),
 	}
-
-	if info.WsEnable {
-		if info.Wss {
-			n.Address = "wss://" + info.Address
-		} else {
-			n.Address = "ws://" + info.Address
-		}
-	} else {
-		n.Address = "tcp://" + info.Address
-	}
+	n.connectFn = n.connectEndpoints
+	// 连接成功之前显示第一个服务端
+	n.Address = n.endpoints[0].name
 
 	return n
 }
 
 func (mnet *NetNode) Report() NodeReport {
-	return NodeReport{
-		Type:    mnet.Type,
-		Address: mnet.Address,
+	state, since, lastErr := mnet.State()
+	mnet.stateMut.RLock()
+	address, rtt := mnet.Address, mnet.rtt
+	mnet.stateMut.RUnlock()
+	errStr := ""
+	if lastErr != nil {
+		errStr = lastErr.Error()
+	}
+
+	return mnet.fill(NodeReport{
+		Type:       mnet.Type,
+		Address:    address,
+		Domain:     mnet.Domain,
+		Alive:      time.Since(mnet.StartTime),
+		Connected:  atomic.LoadInt32(&mnet.connected) == 1,
+		Reconnects: atomic.LoadInt32(&mnet.Reconnects),
+		State:      state,
+		StateTime:  time.Since(since),
+		LastError:  errStr,
+		RTT:        rtt,
+	})
+}
+
+// State 返回当前状态、进入状态的时间以及最近一次错误
+func (mnet *NetNode) State() (NodeState, time.Time, error) {
+	mnet.stateMut.RLock()
+	defer mnet.stateMut.RUnlock()
+	return mnet.state, mnet.stateTime, mnet.lastErr
+}
+
+// setState 切换状态并通知订阅者，err不为空时记录为最近一次错误
+func (mnet *NetNode) setState(state NodeState, err error) {
+	mnet.stateMut.Lock()
+	from := mnet.state
+	if err != nil {
+		mnet.lastErr = err
+	}
+	if from == state {
+		mnet.stateMut.Unlock()
+		return
+	}
+	mnet.state = state
+	mnet.stateTime = time.Now()
+	mnet.stateMut.Unlock()
+
+	ev := StateEvent{
+		Network: mnet.Type,
 		Domain:  mnet.Domain,
-		Alive:   time.Since(mnet.StartTime),
-		Listens: mnet.Listens,
-		Accepts: mnet.Accepts,
-		Dials:   mnet.Dials,
-		Sends:   mnet.Sends,
-		Recvs:   mnet.Recvs,
+		From:    from,
+		To:      state,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		ev.Err = err.Error()
 	}
+	log.Printf("[%v] state changed. %v -> %v\n", mnet.Type, from, state)
+	mnet.publish(ev)
+}
+
+// setAddress 记录当前连接的服务端
+func (mnet *NetNode) setAddress(addr string) {
+	mnet.stateMut.Lock()
+	mnet.Address = addr
+	mnet.stateMut.Unlock()
+}
+
+// RTT 最近一次心跳的往返时长
+func (mnet *NetNode) RTT() time.Duration {
+	mnet.stateMut.RLock()
+	defer mnet.stateMut.RUnlock()
+	return mnet.rtt
+}
+
+func (mnet *NetNode) setRTT(rtt time.Duration) {
+	mnet.stateMut.Lock()
+	mnet.rtt = rtt
+	mnet.stateMut.Unlock()
 }
 
 func (mnet *NetNode) Dial(network, addr string) (net.Conn, error) {
@@ -89,7 +175,11 @@ func (mnet *NetNode) Dial(network, addr string) (net.Conn, error) {
 	if node == nil {
 		return nil, errors.New("dial with nil node")
 	}
-	return node.Dial(addr)
+	conn, err := node.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return mnet.dialed(conn), nil
 }
 
 func (mnet *NetNode) Listen(network, addr string) (net.Listener, error) {
@@ -109,7 +199,11 @@ func (mnet *NetNode) Listen(network, addr string) (net.Listener, error) {
 	if node == nil {
 		return nil, errors.New("listen with nil node")
 	}
-	return node.Listen(uint16(port))
+	l, err := node.Listen(uint16(port))
+	if err != nil {
+		return nil, err
+	}
+	return mnet.listened(l), nil
 }
 
 func (mnet *NetNode) GetNode() (*node.Node, error) {
@@ -120,6 +214,10 @@ func (mnet *NetNode) GetNode() (*node.Node, error) {
 		return mnet.node, nil
 	}
 
+	if mnet.closed {
+		return nil, errors.New("network closed")
+	}
+
 	if mnet.connectFn == nil {
 		return nil, errors.New("need call SetConnectFunc first")
 	}
@@ -130,6 +228,7 @@ func (mnet *NetNode) GetNode() (*node.Node, error) {
 	}
 
 	mnet.node = node
+	atomic.StoreInt32(&mnet.connected, 1)
 	return mnet.node, nil
 }
 
@@ -137,45 +236,102 @@ func (mnet *NetNode) ResetNode() {
 	mnet.nodeMut.Lock()
 	defer mnet.nodeMut.Unlock()
 	mnet.node = nil
+	atomic.StoreInt32(&mnet.connected, 0)
+}
+
+// shutdown 断开与服务端的连接，之后不再重连
+func (mnet *NetNode) shutdown() {
+	mnet.nodeMut.Lock()
+	mnet.closed = true
+	node := mnet.node
+	mnet.node = nil
+	atomic.StoreInt32(&mnet.connected, 0)
+	mnet.nodeMut.Unlock()
+
+	if node != nil {
+		node.Close()
+	}
 }
 
 func (mnet *NetNode) SetConnectFunc(fn ConnectFunc) {
 	mnet.connectFn = fn
 }
 
-func (mnet *NetNode) KeepAlive(evch chan struct{}) {
-	dur := time.Second * 0
-	minWaitDur := 3 * time.Second
-	maxWaitDur := 1 * time.Minute
-	minRunDur := 30 * time.Second // 至少执行30秒
-	durStep := 3 * time.Second
+// KeepAlive 保持与服务端的连接，状态变化通过Subscribe获取
+// ctx结束时断开连接并返回，之后Dial、Listen都会失败
+// 重连前的等待由BackoffInfo决定
+func (mnet *NetNode) KeepAlive(ctx context.Context) {
+	bo := newBackoff(mnet.backoff)
+	connected := false
+
+	defer func() {
+		mnet.shutdown()
+		mnet.setState(StateDisconnected, ctx.Err())
+	}()
 
 	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var dur time.Duration
+		mnet.setState(StateConnecting, nil)
 		node, err := mnet.GetNode()
 		if err != nil {
-			// 如果发生错误，打印错误，然后增加3秒停顿时间
-			dur += durStep
 			log.Printf("connect failed: %v\n", err)
+			if isAuthError(err) {
+				dur = bo.nextAuth()
+				mnet.setState(StateAuthFailed, err)
+			} else {
+				dur = bo.next()
+				mnet.setState(StateBackoff, err)
+			}
 		} else {
-			select {
-			case evch <- struct{}{}:
-			default:
+			if connected {
+				atomic.AddInt32(&mnet.Reconnects, 1)
 			}
+			connected = true
+			bo.connected()
+			mnet.setState(StateOnline, nil)
 
-			// 等待node.Run返回，并根据执行时间判断停顿时长
+			// 等待node.Run返回，连接保持的时间足够长时重新计算等待时长
 			start := time.Now()
+			stop := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					node.Close()
+				case <-stop:
+				}
+			}()
+			hbErr := make(chan error, 1)
+			go func() { hbErr <- mnet.heartbeat(node, stop) }()
+			notice := make(chan string, 1)
+			go func() { notice <- mnet.serverMessages(node, stop) }()
 			node.Run()
+			close(stop)
 			mnet.ResetNode()
-			dur = minRunDur - time.Since(start)
+			mnet.setRTT(0)
+			if ctx.Err() != nil {
+				return
+			}
+			// 服务端停机前会推送通知，作为断开原因发布
+			reason := errors.New("disconnected from server")
+			if err := <-hbErr; err != nil {
+				reason = err
+			} else if msg := <-notice; msg != "" {
+				reason = errors.New("disconnected from server: " + msg)
+			}
+			mnet.setState(StateDisconnected, reason)
+			mnet.setState(StateBackoff, nil)
+			bo.disconnected(time.Since(start))
+			dur = bo.next()
 		}
 
-		if dur < minWaitDur {
-			dur = minWaitDur
-		} else if dur > maxWaitDur {
-			dur = maxWaitDur
+		log.Printf("connect to server after %v\n", dur.Round(time.Millisecond))
+		select {
+		case <-time.After(dur):
+		case <-ctx.Done():
 		}
-
-		log.Printf("connect to server after %v\n", dur)
-		<-time.After(dur)
 	}
 }
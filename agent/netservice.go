@@ -1,22 +1,34 @@
 package agent
 
+import "context"
+
 type Service interface {
 	Name() string
 	Network() string
 	Init() error
-	Start() error
+	Start(ctx context.Context) error // ctx结束时关闭监听并中断活跃的连接
 	Close() error
 	Update() error // 依赖的netnode重连后，能够更新runner
 	Report() ReportInfo
 }
 
+// 服务状态
+const (
+	ServiceWaiting = "waiting" // 依赖的网络还没有上线，上线后通过Update完成监听
+	ServiceRunning = "running"
+	ServiceStopped = "stopped"
+)
+
 type ReportInfos []ReportInfo
 
 type ReportInfo struct {
-	Name    string
-	State   string
-	Listen  string
-	Target  string
-	Actives int32
-	Dones   int32
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	Listen     string `json:"listen"`
+	Target     string `json:"target"`
+	Actives    int32  `json:"actives"`
+	Dones      int32  `json:"dones"`
+	DialErrors int32  `json:"dialErrors"` // 连接目标失败的次数
+	Sends      int64  `json:"sends"`      // 发往目标的字节数
+	Recvs      int64  `json:"recvs"`      // 从目标收到的字节数
 }
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// NodeState 网络与服务端之间的连接状态
+type NodeState string
+
+const (
+	StateDisconnected NodeState = "disconnected" // 未连接，或者连接刚刚断开
+	StateConnecting   NodeState = "connecting"   // 正在连接服务端
+	StateOnline       NodeState = "online"       // 已连接
+	StateBackoff      NodeState = "backoff"      // 连接失败或断开，等待重连
+	StateAuthFailed   NodeState = "auth-failed"  // 服务端拒绝了握手密码，等待重连
+)
+
+// AllNodeStates 所有状态，用于输出指标
+var AllNodeStates = []NodeState{StateDisconnected, StateConnecting, StateOnline, StateBackoff, StateAuthFailed}
+
+// StateEvent 网络状态变化事件
+type StateEvent struct {
+	Network string    `json:"network"`
+	Domain  string    `json:"domain"`
+	From    NodeState `json:"from"`
+	To      NodeState `json:"to"`
+	Err     string    `json:"err"` // 导致状态变化的错误，可能为空
+	Time    time.Time `json:"time"`
+}
+
+// eventBufSize 订阅者的缓冲大小，缓冲满时丢弃事件（当前状态仍然可以通过Report获取）
+const eventBufSize = 32
+
+// eventBus 状态事件的发布与订阅
+type eventBus struct {
+	subs map[chan StateEvent]struct{}
+	mut  sync.Mutex
+}
+
+// Subscribe 订阅状态事件，调用返回的cancel函数取消订阅并关闭channel
+func (bus *eventBus) Subscribe() (<-chan StateEvent, func()) {
+	ch := make(chan StateEvent, eventBufSize)
+
+	bus.mut.Lock()
+	if bus.subs == nil {
+		bus.subs = make(map[chan StateEvent]struct{})
+	}
+	bus.subs[ch] = struct{}{}
+	bus.mut.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			bus.mut.Lock()
+			delete(bus.subs, ch)
+			bus.mut.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (bus *eventBus) publish(ev StateEvent) {
+	bus.mut.Lock()
+	defer bus.mut.Unlock()
+
+	for ch := range bus.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// isAuthError 判断握手错误是否为密码校验失败
+func isAuthError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "invalid checksum")
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+func TestEventBus(t *testing.T) {
+	var bus eventBus
+	ch1, cancel1 := bus.Subscribe()
+	ch2, cancel2 := bus.Subscribe()
+	defer cancel2()
+
+	bus.publish(StateEvent{To: StateOnline})
+	for _, ch := range []<-chan StateEvent{ch1, ch2} {
+		select {
+		case ev := <-ch:
+			if ev.To != StateOnline {
+				t.Error("unexpected event", ev)
+			}
+		default:
+			t.Error("event not received")
+			return
+		}
+	}
+
+	// 取消订阅后channel关闭，重复取消不会panic
+	cancel1()
+	cancel1()
+	if _, ok := <-ch1; ok {
+		t.Error("channel should be closed")
+		return
+	}
+	bus.publish(StateEvent{To: StateBackoff})
+	if ev := <-ch2; ev.To != StateBackoff {
+		t.Error("unexpected event", ev)
+	}
+
+	// 缓冲满时丢弃事件，不阻塞发布者
+	for i := 0; i < eventBufSize+8; i++ {
+		bus.publish(StateEvent{To: StateConnecting})
+	}
+	if len(ch2) != eventBufSize {
+		t.Error("unexpected buffered events", len(ch2))
+	}
+}
+
+// 握手密码错误时返回的错误包含 "invalid checksum"，isAuthError依赖这一字符串
+func TestIsAuthError(t *testing.T) {
+	app := switcher.NewServer("pswd")
+	c1, c2 := packet.Pipe()
+	go app.ServeConn(c2)
+	_, err := switcher.UpgradeToNode(c1, "agent", "mac", "wrong")
+	if err == nil {
+		t.Error("handshake should fail")
+		return
+	}
+	if !isAuthError(err) {
+		t.Errorf("not treated as auth error: %v", err)
+	}
+	if isAuthError(nil) || isAuthError(errors.New("dial timeout")) {
+		t.Error("other errors should not be auth errors")
+	}
+}
+
+// 依次经过密码错误、连接失败、上线、断开重连与退出
+func TestNetNodeStateTransitions(t *testing.T) {
+	app := switcher.NewServer("pswd")
+	var mut sync.Mutex
+	var current *node.Node
+	attempts := 0
+
+	mnet := NewNetwork(AgentInfo{
+		Network:   "flex",
+		Address:   "localhost:2000",
+		Domain:    "agent",
+		Backoff:   BackoffInfo{Initial: 1, AuthInitial: 1, Jitter: -1},
+		Heartbeat: HeartbeatInfo{Interval: -1},
+	})
+	mnet.SetConnectFunc(func() (*node.Node, error) {
+		mut.Lock()
+		defer mut.Unlock()
+		attempts++
+		switch attempts {
+		case 1:
+			return nil, errors.New("close by peer: invalid checksum detected")
+		case 2:
+			return nil, errors.New("dial timeout")
+		}
+		n, err := connectSwitcher(app, "agent")
+		current = n
+		return n, err
+	})
+
+	events, cancelSub := mnet.Subscribe()
+	defer cancelSub()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		mnet.KeepAlive(ctx)
+		close(done)
+	}()
+
+	expect := func(states ...NodeState) bool {
+		for _, state := range states {
+			select {
+			case ev := <-events:
+				if ev.To != state {
+					t.Errorf("expect state '%v', got '%v' (from '%v')", state, ev.To, ev.From)
+					return false
+				}
+				if state == StateAuthFailed && !strings.Contains(ev.Err, "invalid checksum") {
+					t.Error("auth error not recorded", ev.Err)
+					return false
+				}
+			case <-time.After(time.Second * 3):
+				t.Errorf("wait state '%v' timeout", state)
+				return false
+			}
+		}
+		return true
+	}
+
+	if !expect(StateConnecting, StateAuthFailed, StateConnecting, StateBackoff, StateConnecting, StateOnline) {
+		return
+	}
+	if r := mnet.Report(); !r.Connected || r.Reconnects != 0 {
+		t.Errorf("unexpected report: %+v", r)
+		return
+	}
+
+	// 服务端断开后重连
+	mut.Lock()
+	current.Close()
+	mut.Unlock()
+	if !expect(StateDisconnected, StateBackoff, StateConnecting, StateOnline) {
+		return
+	}
+	if r := mnet.Report(); r.Reconnects != 1 {
+		t.Error("unexpected reconnects", r.Reconnects)
+		return
+	}
+
+	cancel()
+	if !expect(StateDisconnected) {
+		return
+	}
+	<-done
+	if r := mnet.Report(); r.Connected || r.State != StateDisconnected {
+		t.Errorf("unexpected report after exit: %+v", r)
+	}
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"log"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+)
+
+// noticeConn 记录服务端推送的消息（例如停机通知）
+// flex的node不处理推送消息，需要在读取数据包时截获
+type noticeConn struct {
+	packet.Conn
+	msgs chan string
+}
+
+func newNoticeConn(pc packet.Conn) *noticeConn {
+	return &noticeConn{Conn: pc, msgs: make(chan string, 4)}
+}
+
+func (pc *noticeConn) ReadBuffer() (*packet.Buffer, error) {
+	pbuf, err := pc.Conn.ReadBuffer()
+	if err == nil && pbuf.Cmd() == packet.CmdPushMessage {
+		select {
+		case pc.msgs <- string(pbuf.Payload):
+		default:
+		}
+	}
+	return pbuf, err
+}
+
+func (pc *noticeConn) messages() <-chan string { return pc.msgs }
+
+// serverMessages 记录服务端推送的消息，连接断开后返回最后一条消息
+// 通过SetConnectFunc自定义的连接无法截获消息，直接返回空
+func (mnet *NetNode) serverMessages(n *node.Node, stop <-chan struct{}) string {
+	pc, ok := n.Conn.(interface{ messages() <-chan string })
+	if !ok {
+		return ""
+	}
+	msgs := pc.messages()
+
+	last := ""
+	for {
+		select {
+		case msg := <-msgs:
+			log.Printf("[%v] message from server: %v\n", mnet.Type, msg)
+			last = msg
+		case <-stop:
+			select {
+			case msg := <-msgs:
+				last = msg
+			default:
+			}
+			return last
+		}
+	}
+}
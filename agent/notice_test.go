@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+)
+
+func TestServerMessages(t *testing.T) {
+	c1, c2 := packet.Pipe()
+	n := node.New(newNoticeConn(c1))
+	go n.Run()
+	defer n.Close()
+
+	mnet := NewNetwork(AgentInfo{Network: "flex", Address: "localhost:2000", Domain: "agent"})
+	stop := make(chan struct{})
+	notice := make(chan string, 1)
+	go func() { notice <- mnet.serverMessages(n, stop) }()
+
+	pbuf := packet.NewBuffer(nil)
+	pbuf.SetCmd(packet.CmdPushMessage)
+	pbuf.SetPayload([]byte("server is shutting down"))
+	if err := c2.WriteBuffer(pbuf); err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(stop)
+
+	if msg := <-notice; msg != "server is shutting down" {
+		t.Errorf("unexpected message: '%v'", msg)
+	}
+}
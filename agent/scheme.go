@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// SchemeFactory 根据配置创建网络，注册后可以直接在URL中作为scheme使用
+// 调用时持有hub的锁，不能阻塞，也不能访问hub
+type SchemeFactory func(info NetworkInfo) (Network, error)
+
+var (
+	schemes   = make(map[string]SchemeFactory)
+	schemeMut sync.RWMutex
+)
+
+func init() {
+	RegisterScheme("tcp", newStdNetwork)
+	RegisterScheme("tcp4", newStdNetwork)
+	RegisterScheme("tcp6", newStdNetwork)
+	RegisterScheme("unix", newStdNetwork)
+	RegisterScheme("mem", newMemNetwork)
+}
+
+// RegisterScheme 注册scheme，通常在init中调用，重复注册时panic
+// 注册后DialURL、ListenURL遇到同名的scheme时自动创建网络，也可以在配置的networks中使用其它名称创建
+func RegisterScheme(scheme string, factory SchemeFactory) {
+	if scheme == "" || factory == nil {
+		panic("agent: invalid scheme factory")
+	}
+	schemeMut.Lock()
+	defer schemeMut.Unlock()
+	if _, found := schemes[scheme]; found {
+		panic(fmt.Sprintf("agent: scheme '%v' registered twice", scheme))
+	}
+	schemes[scheme] = factory
+}
+
+// NewSchemeNetwork 使用注册的scheme创建网络，info.Scheme为空时与info.Name相同
+func NewSchemeNetwork(info NetworkInfo) (Network, error) {
+	if info.Scheme == "" {
+		info.Scheme = info.Name
+	}
+	schemeMut.RLock()
+	factory, found := schemes[info.Scheme]
+	schemeMut.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("scheme '%v' not registered", info.Scheme)
+	}
+	return factory(info)
+}
+
+// stdnetwork 标准库net包提供的网络：tcp、tcp4、tcp6、unix
+type stdnetwork struct {
+	netCounter
+	Type    string
+	network string // 传给net.Dial与net.Listen的network
+}
+
+func newStdNetwork(info NetworkInfo) (Network, error) {
+	return &stdnetwork{Type: info.Name, network: info.Scheme}, nil
+}
+
+func (std *stdnetwork) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.Dial(std.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return std.dialed(conn), nil
+}
+func (std *stdnetwork) Listen(network, addr string) (net.Listener, error) {
+	l, err := net.Listen(std.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return std.listened(l), nil
+}
+func (std *stdnetwork) Report() NodeReport {
+	return std.fill(NodeReport{
+		Type:      std.Type,
+		Connected: true,
+		State:     StateOnline,
+	})
+}
+
+// memnetwork 进程内的网络，通过net.Pipe连接同一个网络上的Dial与Listen，用于测试与嵌入
+type memnetwork struct {
+	netCounter
+	Type string
+	lsns map[string]*memListener
+	mut  sync.Mutex
+}
+
+func newMemNetwork(info NetworkInfo) (Network, error) {
+	return &memnetwork{Type: info.Name, lsns: make(map[string]*memListener)}, nil
+}
+
+func (mem *memnetwork) Dial(network, addr string) (net.Conn, error) {
+	mem.mut.Lock()
+	l, found := mem.lsns[addr]
+	mem.mut.Unlock()
+	if !found {
+		return nil, fmt.Errorf("dial %v://%v: connection refused", mem.Type, addr)
+	}
+
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- memConn{remote, netAddr{mem.Type, addr}, netAddr{mem.Type, "dialer"}}:
+	case <-l.done:
+		return nil, fmt.Errorf("dial %v://%v: connection refused", mem.Type, addr)
+	}
+	return mem.dialed(memConn{local, netAddr{mem.Type, "dialer"}, netAddr{mem.Type, addr}}), nil
+}
+
+func (mem *memnetwork) Listen(network, addr string) (net.Listener, error) {
+	mem.mut.Lock()
+	defer mem.mut.Unlock()
+	if _, found := mem.lsns[addr]; found {
+		return nil, fmt.Errorf("listen %v://%v: address already in use", mem.Type, addr)
+	}
+	l := &memListener{
+		mem:   mem,
+		addr:  netAddr{mem.Type, addr},
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	mem.lsns[addr] = l
+	return mem.listened(l), nil
+}
+
+func (mem *memnetwork) Report() NodeReport {
+	return mem.fill(NodeReport{
+		Type:      mem.Type,
+		Connected: true,
+		State:     StateOnline,
+	})
+}
+
+type memListener struct {
+	mem   *memnetwork
+	addr  netAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *memListener) Close() error {
+	l.once.Do(func() {
+		l.mem.mut.Lock()
+		delete(l.mem.lsns, l.addr.addr)
+		l.mem.mut.Unlock()
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
+
+// memConn net.Pipe的地址为pipe，替换为网络名称与监听地址
+type memConn struct {
+	net.Conn
+	local  netAddr
+	remote netAddr
+}
+
+func (c memConn) LocalAddr() net.Addr  { return c.local }
+func (c memConn) RemoteAddr() net.Addr { return c.remote }
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+// TLSInfo agent连接服务端时的TLS配置
+type TLSInfo struct {
+	Enable      bool   `json:"enable" toml:"enable"`           // tcp连接是否启用TLS
+	CAFile      string `json:"caFile" toml:"caFile"`           // 用于校验服务端证书的CA文件
+	Fingerprint string `json:"fingerprint" toml:"fingerprint"` // 服务端证书的sha256指纹，设置后不再校验证书链
+	ServerName  string `json:"serverName" toml:"serverName"`   // 校验证书使用的域名，默认取服务端地址
+}
+
+// ClientConfig 根据配置生成tls.Config
+func (info *TLSInfo) ClientConfig(address string) (*tls.Config, error) {
+	serverName := info.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	cfg := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if info.CAFile != "" {
+		buf, err := ioutil.ReadFile(info.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file failed: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, errors.New("no certificate found in ca file")
+		}
+		cfg.RootCAs = pool
+	}
+
+	if info.Fingerprint != "" {
+		expect := utils.NormalizeFingerprint(info.Fingerprint)
+		// 证书指纹固定的情况下，只校验服务端证书是否与指纹一致
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented by server")
+			}
+			if utils.CertFingerprint(rawCerts[0]) != expect {
+				return errors.New("server certificate fingerprint mismatch")
+			}
+			return nil
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+// newTestCert 生成localhost的自签名证书
+func newTestCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsHandshake 使用info与证书为cert的服务端完成一次握手
+func tlsHandshake(t *testing.T, cert tls.Certificate, info TLSInfo) error {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.(*tls.Conn).Handshake()
+		c.Close()
+	}()
+
+	cfg, err := info.ClientConfig(l.Addr().String())
+	if err != nil {
+		return err
+	}
+	c, err := tls.Dial("tcp", l.Addr().String(), cfg)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func TestTLSFingerprint(t *testing.T) {
+	cert := newTestCert(t)
+	fp := utils.CertFingerprint(cert.Certificate[0])
+
+	// 没有CA与指纹时，自签名证书无法通过校验
+	if err := tlsHandshake(t, cert, TLSInfo{}); err == nil {
+		t.Error("self-signed cert should be rejected")
+		return
+	}
+
+	// 指纹支持大写与冒号分隔
+	var parts []string
+	for i := 0; i < len(fp); i += 2 {
+		parts = append(parts, strings.ToUpper(fp[i:i+2]))
+	}
+	for _, pin := range []string{fp, strings.Join(parts, ":")} {
+		if err := tlsHandshake(t, cert, TLSInfo{Fingerprint: pin}); err != nil {
+			t.Errorf("pinned handshake failed: %v", err)
+			return
+		}
+	}
+
+	// 指纹固定后不校验域名，但证书必须与指纹一致
+	if err := tlsHandshake(t, cert, TLSInfo{Fingerprint: fp, ServerName: "other.example.com"}); err != nil {
+		t.Errorf("pinned handshake failed: %v", err)
+		return
+	}
+	other := utils.CertFingerprint(newTestCert(t).Certificate[0])
+	err := tlsHandshake(t, cert, TLSInfo{Fingerprint: other})
+	if err == nil || !strings.Contains(err.Error(), "fingerprint mismatch") {
+		t.Errorf("mismatched fingerprint should fail, err=%v", err)
+		return
+	}
+}
+
+func TestTLSCAFile(t *testing.T) {
+	cert := newTestCert(t)
+	dir, err := ioutil.TempDir("", "remotework-tls")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	buf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err = ioutil.WriteFile(caFile, buf, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = tlsHandshake(t, cert, TLSInfo{CAFile: caFile}); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = tlsHandshake(t, cert, TLSInfo{CAFile: caFile, ServerName: "other.example.com"}); err == nil {
+		t.Error("hostname mismatch should fail")
+		return
+	}
+}
@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/net-agent/remotework/utils"
+	"github.com/net-agent/socks"
+)
+
+const upstreamTimeout = time.Second * 10
+
+// proxyFromEnvironment 读取代理环境变量，测试时替换（标准库只在第一次调用时读取环境变量）
+var proxyFromEnvironment = http.ProxyFromEnvironment
+
+// dialFunc 连接服务端使用的拨号函数，与websocket.Dialer.NetDial一致
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// upstreamProxy 连接ep使用的上游代理，为空时直接连接
+// 没有配置upstreamProxy时使用HTTPS_PROXY、HTTP_PROXY、NO_PROXY环境变量，配置为direct时忽略环境变量
+func (agent *AgentInfo) upstreamProxy(ep EndpointInfo) (*url.URL, error) {
+	switch agent.UpstreamProxy {
+	case "direct":
+		return nil, nil
+	case "":
+		scheme := "https"
+		if ep.WsEnable && !ep.Wss {
+			scheme = "http"
+		}
+		return proxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: ep.Address}})
+	}
+	return url.Parse(agent.UpstreamProxy)
+}
+
+// upstreamDial 返回连接ep使用的拨号函数，代理配置错误时拨号返回该错误
+// 拨号使用family配置的地址族，忽略传入的network
+func (agent *AgentInfo) upstreamDial(ep EndpointInfo) dialFunc {
+	family := agent.dialNetwork()
+	proxy, err := agent.upstreamProxy(ep)
+	if err != nil {
+		err = fmt.Errorf("parse upstream proxy failed: %v", err)
+		return func(network, addr string) (net.Conn, error) { return nil, err }
+	}
+	if proxy == nil {
+		return func(network, addr string) (net.Conn, error) {
+			return net.Dial(family, addr)
+		}
+	}
+
+	var dial dialFunc
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		var auth socks.Auth
+		if proxy.User != nil {
+			pswd, _ := proxy.User.Password()
+			auth = socks.AuthPswd(proxy.User.Username(), pswd)
+		}
+		resolve := proxy.Scheme == "socks5"
+		dial = func(network, addr string) (net.Conn, error) {
+			if resolve {
+				tcpAddr, err := net.ResolveTCPAddr(family, addr)
+				if err != nil {
+					return nil, err
+				}
+				addr = tcpAddr.String()
+			}
+			conn, err := net.DialTimeout(family, proxyHost(proxy, "1080"), upstreamTimeout)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(time.Now().Add(upstreamTimeout))
+			if err = utils.SocksConnect(conn, auth, addr); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn.SetDeadline(time.Time{})
+			return conn, nil
+		}
+	case "http", "https":
+		dial = func(network, addr string) (net.Conn, error) {
+			return httpConnect(family, proxy, addr)
+		}
+	default:
+		err = fmt.Errorf("unsupported upstream proxy scheme '%v'", proxy.Scheme)
+		return func(network, addr string) (net.Conn, error) { return nil, err }
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		log.Printf("connect to '%v' via proxy '%v'\n", addr, proxy.Redacted())
+		return dial(network, addr)
+	}
+}
+
+func proxyHost(proxy *url.URL, defaultPort string) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	return net.JoinHostPort(proxy.Hostname(), defaultPort)
+}
+
+// httpConnect 通过http代理的CONNECT方法连接addr
+func httpConnect(network string, proxy *url.URL, addr string) (net.Conn, error) {
+	defaultPort := "80"
+	if proxy.Scheme == "https" {
+		defaultPort = "443"
+	}
+	conn, err := net.DialTimeout(network, proxyHost(proxy, defaultPort), upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+	if proxy.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err = tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		pswd, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + pswd))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy refused: %v", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 读取代理回应时多读的数据需要先返回
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// dialTimeout 在timeout内完成拨号，超时后到达的连接直接关闭
+func dialTimeout(dial dialFunc, network, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := dial(network, addr)
+		ch <- result{c, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errors.New("dial timeout")
+	}
+}
@@ -0,0 +1,230 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// serveProxy 在本地监听，每个连接交给handle处理
+func serveProxy(t *testing.T, handle func(c net.Conn)) string {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// echoCheck 写入数据并检查回显
+func echoCheck(t *testing.T, c net.Conn) {
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Error("echo failed", err, string(buf))
+	}
+}
+
+func TestUpstreamHTTPConnect(t *testing.T) {
+	reqs := make(chan *http.Request, 1)
+	addr := serveProxy(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		reqs <- req
+		if req.Header.Get("Proxy-Authorization") == "" {
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		// 回应之后紧跟的数据需要通过bufferedConn读取
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\nhi")
+		io.Copy(c, br)
+	})
+
+	agent := AgentInfo{UpstreamProxy: "http://user:pass@" + addr}
+	c, err := agent.upstreamDial(EndpointInfo{Address: "server.example.com:2000"})("tcp", "server.example.com:2000")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer c.Close()
+	req := <-reqs
+	if req.Method != http.MethodConnect || req.Host != "server.example.com:2000" {
+		t.Errorf("unexpected request: %v %v", req.Method, req.Host)
+		return
+	}
+	if auth := req.Header.Get("Proxy-Authorization"); auth != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")) {
+		t.Errorf("unexpected proxy auth: %v", auth)
+		return
+	}
+	buf := make([]byte, 2)
+	if _, err = io.ReadFull(c, buf); err != nil || string(buf) != "hi" {
+		t.Error("buffered data lost", err)
+		return
+	}
+	echoCheck(t, c)
+
+	agent.UpstreamProxy = "http://" + addr
+	_, err = agent.upstreamDial(EndpointInfo{Address: "server.example.com:2000"})("tcp", "server.example.com:2000")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Error("expect proxy refused", err)
+	}
+}
+
+// socksTarget socks5请求中的目标地址
+type socksTarget struct {
+	atyp byte
+	host string
+	port int
+}
+
+// serveSocks 模拟socks5代理，记录请求的目标后回显数据
+func serveSocks(t *testing.T) (string, chan socksTarget) {
+	targets := make(chan socksTarget, 1)
+	addr := serveProxy(t, func(c net.Conn) {
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(c, head); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, make([]byte, head[1])); err != nil {
+			return
+		}
+		c.Write([]byte{5, 0})
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		var host []byte
+		switch req[3] {
+		case 1:
+			host = make([]byte, net.IPv4len)
+		case 4:
+			host = make([]byte, net.IPv6len)
+		case 3:
+			size := make([]byte, 1)
+			io.ReadFull(c, size)
+			host = make([]byte, size[0])
+		}
+		port := make([]byte, 2)
+		io.ReadFull(c, host)
+		if _, err := io.ReadFull(c, port); err != nil {
+			return
+		}
+		target := socksTarget{atyp: req[3], host: string(host), port: int(binary.BigEndian.Uint16(port))}
+		if req[3] != 3 {
+			target.host = net.IP(host).String()
+		}
+		targets <- target
+
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		io.Copy(c, c)
+	})
+	return addr, targets
+}
+
+// socks5在本地解析域名，socks5h由代理解析
+func TestUpstreamSocks5(t *testing.T) {
+	addr, targets := serveSocks(t)
+	cases := []struct {
+		proxy  string
+		family string
+		target string
+		expect socksTarget
+	}{
+		{"socks5h://" + addr, "ipv4", "server.example.com:2000", socksTarget{3, "server.example.com", 2000}},
+		{"socks5://" + addr, "ipv4", "localhost:2000", socksTarget{1, "127.0.0.1", 2000}},
+		{"socks5://" + addr, "", "[::1]:2000", socksTarget{4, "::1", 2000}},
+	}
+	for _, c := range cases {
+		agent := AgentInfo{UpstreamProxy: c.proxy, Family: c.family}
+		conn, err := agent.upstreamDial(EndpointInfo{Address: c.target})("tcp", c.target)
+		if err != nil {
+			t.Error(c.proxy, c.target, err)
+			return
+		}
+		if target := <-targets; target != c.expect {
+			t.Errorf("%v %v: unexpected target %+v", c.proxy, c.target, target)
+		}
+		echoCheck(t, conn)
+		conn.Close()
+	}
+
+	// 本地无法解析时不连接代理
+	agent := AgentInfo{UpstreamProxy: "socks5://" + addr}
+	if _, err := agent.upstreamDial(EndpointInfo{})("tcp", "server.invalid:2000"); err == nil {
+		t.Error("resolve should fail")
+	}
+}
+
+// 没有配置时使用环境变量中的代理，direct忽略环境变量
+func TestUpstreamProxyEnvironment(t *testing.T) {
+	var schemes []string
+	envProxy, _ := url.Parse("http://env-proxy:3128")
+	proxyFromEnvironment = func(req *http.Request) (*url.URL, error) {
+		schemes = append(schemes, req.URL.Scheme)
+		if req.URL.Host == "noproxy.example.com:2000" {
+			return nil, nil
+		}
+		return envProxy, nil
+	}
+	defer func() { proxyFromEnvironment = http.ProxyFromEnvironment }()
+
+	agent := AgentInfo{}
+	eps := []EndpointInfo{
+		{Address: "server.example.com:2000"},
+		{Address: "server.example.com:2000", WsEnable: true},
+		{Address: "server.example.com:2000", WsEnable: true, Wss: true},
+	}
+	for _, ep := range eps {
+		if proxy, err := agent.upstreamProxy(ep); err != nil || proxy != envProxy {
+			t.Error("expect env proxy", proxy, err)
+			return
+		}
+	}
+	if strings.Join(schemes, ",") != "https,http,https" {
+		t.Error("unexpected schemes", schemes)
+		return
+	}
+	if proxy, _ := agent.upstreamProxy(EndpointInfo{Address: "noproxy.example.com:2000"}); proxy != nil {
+		t.Error("no_proxy host should connect directly", proxy)
+	}
+
+	schemes = nil
+	agent.UpstreamProxy = "direct"
+	if proxy, err := agent.upstreamProxy(eps[0]); proxy != nil || err != nil || len(schemes) != 0 {
+		t.Error("direct should ignore environment", proxy, err)
+	}
+	agent.UpstreamProxy = "socks5://127.0.0.1:1080"
+	if proxy, _ := agent.upstreamProxy(eps[0]); proxy == nil || proxy.Scheme != "socks5" || len(schemes) != 0 {
+		t.Error("configured proxy should override environment", proxy)
+	}
+
+	agent.UpstreamProxy = "ftp://127.0.0.1:21"
+	if _, err := agent.upstreamDial(eps[0])("tcp", eps[0].Address); err == nil || !strings.Contains(err.Error(), "unsupported") {
+		t.Error("expect unsupported scheme", err)
+	}
+}
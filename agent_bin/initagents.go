@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"log"
-	"sync"
 
 	"github.com/net-agent/remotework/agent"
 )
 
-func initAgents(hub *agent.NetHub, agents []agent.AgentInfo) {
+// initAgents 注册所有网络并在后台连接服务端，不等待连接成功
+// 依赖这些网络的服务先处于waiting状态，网络上线后由hub触发更新
+func initAgents(ctx context.Context, hub *agent.NetHub, agents []agent.AgentInfo) {
 	log.Println("startup agents:")
 
 	runcount := 0
@@ -15,30 +17,12 @@ func initAgents(hub *agent.NetHub, agents []agent.AgentInfo) {
 
 		mnet := agent.NewNetwork(info)
 
-		var wg sync.WaitGroup
-		wg.Add(1)
-		go func(network string) {
-			ch := make(chan struct{}, 2)
-			go mnet.KeepAlive(ch)
-
-			done := false
-			for range ch {
-				// 重连后，触发hub的网络更新事件
-				hub.TriggerNetworkUpdate(network)
-
-				if !done {
-					done = true
-					wg.Done()
-				}
-			}
-		}(info.Network)
-		wg.Wait()
-
 		err := hub.AddNetwork(info.Network, mnet)
 		if err != nil {
 			log.Printf("add network failed. network='%v', err=%v\n", info.Network, err)
 			continue
 		}
+		hub.KeepAlive(ctx, mnet)
 
 		runcount++
 	}
@@ -46,3 +30,13 @@ func initAgents(hub *agent.NetHub, agents []agent.AgentInfo) {
 		log.Println("WARN: NO AGENTS ARE RUNNING")
 	}
 }
+
+// initNetworks 使用注册的scheme创建配置中的网络
+func initNetworks(hub *agent.NetHub, networks []agent.NetworkInfo) {
+	for _, info := range networks {
+		err := hub.AddScheme(info)
+		if err != nil {
+			log.Printf("add network failed. network='%v', err=%v\n", info.Name, err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/net-agent/remotework/agent"
+)
+
+func initMonitor(ctx context.Context, hub *agent.NetHub, info agent.MonitorInfo) {
+	if !info.Enable {
+		return
+	}
+	go func() {
+		err := hub.ServeMonitor(ctx, info)
+		log.Printf("[monitor] stopped: %v\n", err)
+	}()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -8,7 +9,7 @@ import (
 	"github.com/net-agent/remotework/service"
 )
 
-func initServices(hub *agent.NetHub, cfg *agent.Config) {
+func initServices(ctx context.Context, hub *agent.NetHub, cfg *agent.Config) {
 	log.Println("startup services:")
 
 	hub.AddServices(createTrusts(hub, cfg.Agents)...)
@@ -17,22 +18,38 @@ func initServices(hub *agent.NetHub, cfg *agent.Config) {
 	hub.AddServices(createQuickvisits(hub, cfg.Visit)...)
 	hub.AddServices(createRDPs(hub, cfg.RDP)...)
 
-	hub.StartServices()
+	hub.StartServices(ctx)
 }
 
+// createTrusts 每个网络一个trust服务，同名网络的多个agent合并白名单
 func createTrusts(hub *agent.NetHub, agents []agent.AgentInfo) []agent.Service {
-	svcs := []agent.Service{}
+	var networks []string
+	whiteLists := make(map[string]map[string]string)
 	for _, info := range agents {
-		if info.QuickTrust.Enable {
-			svc := service.NewQuickTrust(
-				hub,
-				info.Network,
-				info.QuickTrust.WhiteList,
-				fmt.Sprintf("trust-%v", info.Network),
-			)
-			svcs = append(svcs, svc)
+		if !info.QuickTrust.Enable {
+			continue
+		}
+		list, found := whiteLists[info.Network]
+		if !found {
+			list = make(map[string]string)
+			whiteLists[info.Network] = list
+			networks = append(networks, info.Network)
+		}
+		for domain, pswd := range info.QuickTrust.WhiteList {
+			list[domain] = pswd
 		}
 	}
+
+	svcs := []agent.Service{}
+	for _, network := range networks {
+		svc := service.NewQuickTrust(
+			hub,
+			network,
+			whiteLists[network],
+			fmt.Sprintf("trust-%v", network),
+		)
+		svcs = append(svcs, svc)
+	}
 	return svcs
 }
 
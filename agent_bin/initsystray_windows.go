@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -25,6 +26,14 @@ func initSysTray(hub *agent.NetHub) {
 		systray.SetTitle("init systray title")
 		systray.SetTooltip("Make remotework easy again!")
 
+		// 网络状态变化时更新提示信息
+		events, _ := hub.Subscribe()
+		go func() {
+			for ev := range events {
+				systray.SetTooltip(fmt.Sprintf("%v: %v", ev.Network, ev.To))
+			}
+		}()
+
 		btnNetwork := systray.AddMenuItem("查看虚拟网络状态", "list all services")
 		go addClickListener(btnNetwork, func() {
 			hub.NetworkReportAscii(os.Stdout)
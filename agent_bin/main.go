@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/net-agent/remotework/agent"
 )
@@ -16,9 +19,22 @@ func main() {
 		defer logoutput.Close()
 	}
 
+	// 收到SIGINT/SIGTERM后停止服务并断开网络
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		sig := <-ch
+		log.Printf("received signal '%v', shutting down.\n", sig)
+		cancel()
+	}()
+
 	hub := agent.NewNetHub()
-	initAgents(hub, config.Agents)
-	initServices(hub, config)
+	initNetworks(hub, config.Networks)
+	initAgents(ctx, hub, config.Agents)
+	initServices(ctx, hub, config)
+	initMonitor(ctx, hub, config.Monitor)
 	initSysTray(hub)
 	defer releaseSysTray()
 
@@ -27,5 +43,6 @@ func main() {
 	hub.ServiceReportAscii(os.Stdout)
 
 	hub.Wait()
+	hub.Close()
 	log.Println("main process exit.")
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Policy domain之间的访问控制策略，规则按顺序匹配，第一条命中的规则生效
+type Policy struct {
+	Default string `json:"default" toml:"default"` // 没有规则命中时的动作，默认为deny
+	Rules   []Rule `json:"rules" toml:"rules"`
+}
+
+// Rule 访问规则，from与to支持通配符（例如 controller-*），ports为空代表所有端口
+type Rule struct {
+	From   string `json:"from" toml:"from"`
+	To     string `json:"to" toml:"to"`
+	Ports  string `json:"ports" toml:"ports"` // 例如 "3389,22,8000-8100"
+	Action string `json:"action" toml:"action"`
+
+	ports [][2]uint16
+}
+
+// LoadPolicy 从json或toml文件中加载访问控制策略
+func LoadPolicy(fpath string) (*Policy, error) {
+	p := &Policy{}
+	var err error
+	switch strings.ToLower(path.Ext(fpath)) {
+	case ".toml":
+		err = utils.LoadTomlFile(fpath, p)
+	default:
+		err = utils.LoadJSONFile(fpath, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = p.init(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Policy) init() error {
+	p.Default = strings.ToLower(p.Default)
+	if p.Default == "" {
+		p.Default = ActionDeny
+	}
+	if p.Default != ActionAllow && p.Default != ActionDeny {
+		return fmt.Errorf("invalid default action '%v'", p.Default)
+	}
+
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		rule.From = strings.ToLower(rule.From)
+		rule.To = strings.ToLower(rule.To)
+		rule.Action = strings.ToLower(rule.Action)
+		if rule.Action == "" {
+			rule.Action = ActionAllow
+		}
+		if rule.Action != ActionAllow && rule.Action != ActionDeny {
+			return fmt.Errorf("rule[%v]: invalid action '%v'", i, rule.Action)
+		}
+		if _, err := path.Match(rule.From, ""); err != nil {
+			return fmt.Errorf("rule[%v]: invalid from pattern '%v'", i, rule.From)
+		}
+		if _, err := path.Match(rule.To, ""); err != nil {
+			return fmt.Errorf("rule[%v]: invalid to pattern '%v'", i, rule.To)
+		}
+		ports, err := parsePorts(rule.Ports)
+		if err != nil {
+			return fmt.Errorf("rule[%v]: %v", i, err)
+		}
+		rule.ports = ports
+	}
+	return nil
+}
+
+// Allow 判断from是否可以连接to的port端口
+func (p *Policy) Allow(from, to string, port uint16) bool {
+	for _, rule := range p.Rules {
+		if rule.match(from, to, port) {
+			return rule.Action == ActionAllow
+		}
+	}
+	return p.Default == ActionAllow
+}
+
+func (rule *Rule) match(from, to string, port uint16) bool {
+	if ok, _ := path.Match(rule.From, from); !ok {
+		return false
+	}
+	if ok, _ := path.Match(rule.To, to); !ok {
+		return false
+	}
+	if len(rule.ports) == 0 {
+		return true
+	}
+	for _, r := range rule.ports {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePorts(str string) ([][2]uint16, error) {
+	var ranges [][2]uint16
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		min, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port '%v'", part)
+		}
+		max := min
+		if len(bounds) == 2 {
+			max, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 16)
+			if err != nil || max < min {
+				return nil, fmt.Errorf("invalid port range '%v'", part)
+			}
+		}
+		ranges = append(ranges, [2]uint16{uint16(min), uint16(max)})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestPolicyAllow(t *testing.T) {
+	p := &Policy{
+		Rules: []Rule{
+			{From: "controller-*", To: "office_pc", Ports: "3389, 22"},
+			{From: "ops", To: "*", Ports: "8000-8100"},
+			{From: "*", To: "office_pc", Action: "deny"},
+			{From: "*", To: "public", Action: "allow"},
+		},
+	}
+	if err := p.init(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		from, to string
+		port     uint16
+		allow    bool
+	}{
+		{"controller-1", "office_pc", 3389, true},
+		{"controller-1", "office_pc", 22, true},
+		{"controller-1", "office_pc", 445, false},
+		{"controller", "office_pc", 3389, false},
+		{"ops", "office_pc", 8050, true},
+		{"ops", "office_pc", 8101, false},
+		{"anyone", "public", 1, true},
+		{"anyone", "other", 1, false},
+	}
+	for index, c := range cases {
+		if p.Allow(c.from, c.to, c.port) != c.allow {
+			t.Errorf("allow not equal, index=%v from='%v' to='%v' port=%v", index, c.from, c.to, c.port)
+			return
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	bad := []string{"abc", "70000", "100-10", "1-x"}
+	for _, str := range bad {
+		if _, err := parsePorts(str); err == nil {
+			t.Errorf("unexpected nil err, ports='%v'", str)
+			return
+		}
+	}
+
+	ranges, err := parsePorts("22,8000-8100,")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(ranges) != 2 || ranges[1][0] != 8000 || ranges[1][1] != 8100 {
+		t.Error("ranges not equal", ranges)
+		return
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/net-agent/remotework/utils"
+)
+
+// AdminInfo 管理接口配置
+type AdminInfo struct {
+	Enable   bool   `json:"enable" toml:"enable"`
+	Path     string `json:"path" toml:"path"`         // 接口路径前缀，默认为 /admin
+	Username string `json:"username" toml:"username"` // 默认为 admin
+	Password string `json:"password" toml:"password"` // 不能为空
+}
+
+// RegisterAdmin 在router上注册管理接口
+func RegisterAdmin(r *mux.Router, relay *Relay, info AdminInfo) error {
+	if info.Password == "" {
+		return errors.New("admin password is empty")
+	}
+	if info.Path == "" {
+		info.Path = "/admin"
+	}
+	if info.Username == "" {
+		info.Username = "admin"
+	}
+
+	sub := r.PathPrefix(strings.TrimRight(info.Path, "/")).Subrouter()
+	sub.Use(utils.BasicAuth(info.Username, info.Password))
+	sub.Methods("GET").Path("/domains").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 按租户分组
+		groups := make(map[string][]SessionReport)
+		for _, s := range relay.Sessions() {
+			report := s.Report()
+			groups[report.Tenant] = append(groups[report.Tenant], report)
+		}
+		utils.WriteJSON(w, nil, groups)
+	})
+	sub.Methods("GET").Path("/traffic").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, nil, relay.Traffics())
+	})
+	sub.Methods("GET").Path("/usage").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, nil, relay.Usages())
+	})
+	sub.Methods("GET").Path("/bans").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.WriteJSON(w, nil, relay.guard.Bans())
+	})
+	sub.Methods("DELETE").Path("/bans/{ip}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !relay.guard.Unban(mux.Vars(r)["ip"]) {
+			utils.WriteJSON(w, errors.New("ip not banned"), nil)
+			return
+		}
+		utils.WriteJSON(w, nil, nil)
+	})
+	sub.Methods("GET").Path("/bindings").HandlerFunc(withBindings(relay, func(w http.ResponseWriter, r *http.Request, b *Bindings) {
+		utils.WriteJSON(w, nil, b.List())
+	}))
+	sub.Methods("DELETE").Path("/bindings/{domain}").HandlerFunc(withBindings(relay, func(w http.ResponseWriter, r *http.Request, b *Bindings) {
+		if !b.Unbind(queryTenant(r), strings.ToLower(mux.Vars(r)["domain"])) {
+			utils.WriteJSON(w, errors.New("binding not found"), nil)
+			return
+		}
+		utils.WriteJSON(w, nil, nil)
+	}))
+	sub.Methods("GET").Path("/approvals").HandlerFunc(withBindings(relay, func(w http.ResponseWriter, r *http.Request, b *Bindings) {
+		utils.WriteJSON(w, nil, b.Approvals())
+	}))
+	sub.Methods("POST").Path("/approvals/{id:[0-9]+}/{action:approve|reject}").HandlerFunc(withBindings(relay, func(w http.ResponseWriter, r *http.Request, b *Bindings) {
+		vars := mux.Vars(r)
+		id, _ := strconv.Atoi(vars["id"])
+		var approval *Approval
+		var err error
+		if vars["action"] == "approve" {
+			approval, err = b.Approve(id)
+		} else {
+			approval, err = b.Reject(id)
+		}
+		utils.WriteJSON(w, err, approval)
+	}))
+	sub.Methods("POST").Path("/reload").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := relay.ReloadConfig()
+		if err != nil {
+			log.Printf("reload config failed: %v\n", err)
+			utils.WriteJSON(w, err, nil)
+			return
+		}
+		utils.WriteJSON(w, nil, result)
+	})
+	sub.Methods("POST").Path("/domains/{domain}/kick").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := queryTenant(r)
+		if relay.Tenant(tenant) == nil {
+			utils.WriteJSON(w, errors.New("tenant not found"), nil)
+			return
+		}
+		domain := mux.Vars(r)["domain"]
+		count := relay.Kick(tenant, domain)
+		if count == 0 {
+			utils.WriteJSON(w, errors.New("domain not found"), nil)
+			return
+		}
+		utils.WriteJSON(w, nil, count)
+	})
+
+	return nil
+}
+
+// queryTenant 读取请求参数中的租户名称，默认为default
+func queryTenant(r *http.Request) string {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant == "" {
+		tenant = DefaultTenant
+	}
+	return tenant
+}
+
+// withBindings 没有启用设备绑定时直接返回错误
+func withBindings(relay *Relay, fn func(http.ResponseWriter, *http.Request, *Bindings)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if relay.bindings == nil {
+			utils.WriteJSON(w, errors.New("device binding not enabled"), nil)
+			return
+		}
+		fn(w, r, relay.bindings)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+	"github.com/net-agent/remotework/utils"
+)
+
+// connectPipe 通过packet.Pipe把domain接入relay
+func connectPipe(t *testing.T, relay *Relay, domain, password string) *node.Node {
+	c1, c2 := packet.Pipe()
+	go relay.ServeConn(c2, domain, "pipe")
+	n, err := switcher.UpgradeToNode(c1, domain, "mac-"+domain, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetDomain(domain)
+	go n.Run()
+	return n
+}
+
+// adminCall 调用管理接口并解析返回的json
+func adminCall(r http.Handler, method, target, username, password string) (int, error) {
+	req := httptest.NewRequest(method, target, nil)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+	return w.Code, utils.ParseRespJSON(w.Body, nil)
+}
+
+func TestAdminAuth(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = RegisterAdmin(mux.NewRouter(), relay, AdminInfo{}); err == nil {
+		t.Error("empty admin password should be rejected")
+		return
+	}
+
+	r := mux.NewRouter()
+	if err = RegisterAdmin(r, relay, AdminInfo{Password: "admin-pswd"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		username, password string
+		code               int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"admin", "wrong", http.StatusUnauthorized},
+		{"root", "admin-pswd", http.StatusUnauthorized},
+		{"admin", "admin-pswd", http.StatusOK},
+	}
+	for _, c := range cases {
+		code, err := adminCall(r, "GET", "/admin/domains", c.username, c.password)
+		if code != c.code || err != nil {
+			t.Errorf("unexpected result, user='%v' code=%v err=%v", c.username, code, err)
+			return
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/admin/domains", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("WWW-Authenticate header expected")
+		return
+	}
+}
+
+func TestAdminKickTenant(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{
+		Password: "pswd",
+		Tenants:  []TenantInfo{{Name: "team", Password: "team-pswd"}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := mux.NewRouter()
+	if err = RegisterAdmin(r, relay, AdminInfo{Password: "admin-pswd"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	n := connectPipe(t, relay, "a", "team-pswd")
+	defer n.Close()
+
+	// 默认租户中没有该domain，不存在的租户直接返回错误
+	if _, err = adminCall(r, "POST", "/admin/domains/a/kick", "admin", "admin-pswd"); err == nil || err.Error() != "domain not found" {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if _, err = adminCall(r, "POST", "/admin/domains/a/kick?tenant=nope", "admin", "admin-pswd"); err == nil || err.Error() != "tenant not found" {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if _, err = adminCall(r, "POST", "/admin/domains/A/kick?tenant=team", "admin", "admin-pswd"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(relay.Sessions()) > 0 {
+		if time.Now().After(deadline) {
+			t.Error("session not closed after kick")
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
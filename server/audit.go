@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/flex/v2/packet"
+)
+
+const (
+	DefaultAuditFile       = "./audit.jsonl"
+	DefaultAuditMaxSize    = 100 // MB
+	DefaultAuditMaxBackups = 5
+)
+
+// AuditInfo 连接审计日志配置，每条连接关闭后写入一行json
+type AuditInfo struct {
+	Enable     bool   `json:"enable" toml:"enable"`
+	File       string `json:"file" toml:"file"`             // 日志文件，默认为 ./audit.jsonl
+	MaxSize    int    `json:"maxSize" toml:"maxSize"`       // 单个文件的最大尺寸（MB），默认100
+	MaxBackups int    `json:"maxBackups" toml:"maxBackups"` // 保留的历史文件数量（file.1 ~ file.N），默认5
+}
+
+// AuditRecord 一条连接的审计记录，以发起连接的domain为视角
+type AuditRecord struct {
+	Tenant     string    `json:"tenant"`
+	Source     string    `json:"source"`
+	SourceAddr string    `json:"sourceAddr"`
+	Dest       string    `json:"dest"`
+	Port       uint16    `json:"port"`
+	OpenTime   time.Time `json:"openTime"`
+	CloseTime  time.Time `json:"closeTime"`
+	Sent       int64     `json:"sent"`     // source发往dest的字节数
+	Received   int64     `json:"received"` // dest发往source的字节数
+	Reason     string    `json:"reason"`   // 关闭原因
+}
+
+// AuditLogger 按尺寸滚动的jsonl日志
+type AuditLogger struct {
+	info AuditInfo
+	file *os.File
+	size int64
+	mut  sync.Mutex
+}
+
+func NewAuditLogger(info AuditInfo) (*AuditLogger, error) {
+	if info.File == "" {
+		info.File = DefaultAuditFile
+	}
+	if info.MaxSize <= 0 {
+		info.MaxSize = DefaultAuditMaxSize
+	}
+	if info.MaxBackups <= 0 {
+		info.MaxBackups = DefaultAuditMaxBackups
+	}
+
+	l := &AuditLogger{info: info}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLogger) open() error {
+	f, err := os.OpenFile(l.info.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = stat.Size()
+	return nil
+}
+
+// Write 写入一条记录，超出尺寸时先滚动文件
+func (l *AuditLogger) Write(rec *AuditRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.file == nil {
+		return errors.New("audit logger closed")
+	}
+	if l.size > 0 && l.size+int64(len(data)) > int64(l.info.MaxSize)<<20 {
+		if err = l.rotate(); err != nil {
+			log.Printf("rotate audit log failed, keep writing to current file: %v\n", err)
+			if l.file == nil {
+				return err
+			}
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	return err
+}
+
+// rotate 依次重命名 file.N-1 -> file.N ... file -> file.1，然后打开新文件
+// 重命名失败时重新打开原文件继续追加，避免后续记录全部丢失
+func (l *AuditLogger) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	for i := l.info.MaxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%v.%v", l.info.File, i), fmt.Sprintf("%v.%v", l.info.File, i+1))
+	}
+	if err := os.Rename(l.info.File, l.info.File+".1"); err != nil {
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return l.open()
+}
+
+func (l *AuditLogger) Close() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// auditTracker 跟踪会话发起的连接，连接关闭后写入审计日志
+type auditTracker struct {
+	logger  *AuditLogger
+	pending map[uint16]*AuditRecord // 等待回应的open请求，key为本端端口
+	streams map[uint64]*AuditRecord
+	mut     sync.Mutex
+}
+
+func newAuditTracker(logger *AuditLogger) *auditTracker {
+	return &auditTracker{
+		logger:  logger,
+		pending: make(map[uint16]*AuditRecord),
+		streams: make(map[uint64]*AuditRecord),
+	}
+}
+
+// onRead 处理agent发出的数据包
+func (t *auditTracker) onRead(s *Session, pbuf *packet.Buffer) {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream:
+		dest := strings.ToLower(string(pbuf.Payload))
+		if dest == "" {
+			dest = s.relay.domainByIP(s.tenant, pbuf.DistIP())
+		}
+		t.mut.Lock()
+		t.pending[pbuf.SrcPort()] = &AuditRecord{
+			Tenant:     s.tenant.Name,
+			Source:     s.Domain,
+			SourceAddr: s.RemoteAddr,
+			Dest:       dest,
+			Port:       pbuf.DistPort(),
+			OpenTime:   time.Now(),
+		}
+		t.mut.Unlock()
+
+	case packet.CmdPushStreamData:
+		t.count(streamKey(pbuf.SrcPort(), pbuf.DistIP(), pbuf.DistPort()), len(pbuf.Payload), true)
+
+	case packet.CmdCloseStream, packet.CmdCloseStream | packet.CmdACKFlag:
+		t.close(streamKey(pbuf.SrcPort(), pbuf.DistIP(), pbuf.DistPort()), "closed by source")
+	}
+}
+
+// onWrite 处理发往agent的数据包
+func (t *auditTracker) onWrite(pbuf *packet.Buffer) {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream | packet.CmdACKFlag:
+		t.mut.Lock()
+		rec, found := t.pending[pbuf.DistPort()]
+		delete(t.pending, pbuf.DistPort())
+		if found && len(pbuf.Payload) == 0 {
+			rec.OpenTime = time.Now()
+			t.streams[streamKey(pbuf.DistPort(), pbuf.SrcIP(), pbuf.SrcPort())] = rec
+		}
+		t.mut.Unlock()
+
+		if found && len(pbuf.Payload) > 0 {
+			rec.CloseTime = time.Now()
+			rec.Reason = "open failed: " + string(pbuf.Payload)
+			t.logger.Write(rec)
+		}
+
+	case packet.CmdPushStreamData:
+		t.count(streamKey(pbuf.DistPort(), pbuf.SrcIP(), pbuf.SrcPort()), len(pbuf.Payload), false)
+
+	case packet.CmdCloseStream, packet.CmdCloseStream | packet.CmdACKFlag:
+		t.close(streamKey(pbuf.DistPort(), pbuf.SrcIP(), pbuf.SrcPort()), "closed by dest")
+	}
+}
+
+func (t *auditTracker) count(key uint64, n int, sent bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	rec, found := t.streams[key]
+	if !found {
+		return
+	}
+	if sent {
+		rec.Sent += int64(n)
+	} else {
+		rec.Received += int64(n)
+	}
+}
+
+func (t *auditTracker) close(key uint64, reason string) {
+	t.mut.Lock()
+	rec, found := t.streams[key]
+	delete(t.streams, key)
+	t.mut.Unlock()
+
+	if found {
+		rec.CloseTime = time.Now()
+		rec.Reason = reason
+		t.logger.Write(rec)
+	}
+}
+
+// closeAll 会话结束时写入所有未关闭的连接
+func (t *auditTracker) closeAll(reason string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	now := time.Now()
+	for _, rec := range t.pending {
+		rec.CloseTime = now
+		rec.Reason = "open failed: no response"
+		t.logger.Write(rec)
+	}
+	for _, rec := range t.streams {
+		rec.CloseTime = now
+		rec.Reason = reason
+		t.logger.Write(rec)
+	}
+	t.pending = make(map[uint16]*AuditRecord)
+	t.streams = make(map[uint64]*AuditRecord)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditLoggerRotate(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := NewAuditLogger(AuditInfo{Enable: true, File: fpath, MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	for i := 0; i < 4; i++ {
+		if err = l.Write(&AuditRecord{Source: "a", Dest: "b", Port: uint16(i)}); err != nil {
+			t.Error(err)
+			return
+		}
+		// 模拟文件写满，下一次写入时滚动
+		l.size = 1 << 20
+	}
+
+	// 最新的记录在当前文件，更早的依次在 .1 与 .2 中，超出数量的被丢弃
+	for suffix, port := range map[string]uint16{"": 3, ".1": 2, ".2": 1} {
+		f, err := os.Open(fpath + suffix)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var rec AuditRecord
+		scanner := bufio.NewScanner(f)
+		if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &rec) != nil || rec.Port != port {
+			t.Errorf("unexpected record in '%v': %+v", suffix, rec)
+		}
+		f.Close()
+	}
+	if _, err = os.Stat(fpath + ".3"); !os.IsNotExist(err) {
+		t.Error("backups should be limited")
+	}
+}
+
+func TestAuditLoggerRotateFailed(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "audit.jsonl")
+	l, err := NewAuditLogger(AuditInfo{Enable: true, File: fpath, MaxSize: 1, MaxBackups: 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer l.Close()
+
+	// 备份位置被非空目录占用，重命名会失败
+	if err = os.MkdirAll(filepath.Join(fpath+".1", "busy"), 0755); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		if err = l.Write(&AuditRecord{Source: "a", Dest: "b", Port: uint16(i)}); err != nil {
+			t.Error(err)
+			return
+		}
+		l.size = 1 << 20
+	}
+
+	// 所有记录都追加在原文件中
+	f, err := os.Open(fpath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	count := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expect 3 records, got %v", count)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+const DefaultBindingFile = "./bindings.json"
+
+const (
+	ConflictReject = "reject"
+	ConflictKick   = "kick"
+)
+
+var (
+	errDeviceMismatch = errors.New("domain is bound to another device, waiting for approval")
+	errDomainOnline   = errors.New("domain is already online")
+)
+
+// BindingInfo domain与设备的绑定配置
+type BindingInfo struct {
+	Enable bool   `json:"enable" toml:"enable"` // 是否将domain绑定到第一次注册的设备
+	File   string `json:"file" toml:"file"`     // 绑定关系的保存文件，默认为 ./bindings.json
+}
+
+// Binding domain绑定的设备
+type Binding struct {
+	Macs      []string  `json:"macs"`
+	BoundTime time.Time `json:"boundTime"`
+}
+
+// Approval 等待管理员审批的注册请求
+type Approval struct {
+	ID         int       `json:"id"`
+	Tenant     string    `json:"tenant"`
+	Domain     string    `json:"domain"`
+	Macs       []string  `json:"macs"`
+	RemoteAddr string    `json:"remoteAddr"`
+	FirstTime  time.Time `json:"firstTime"`
+	LastTime   time.Time `json:"lastTime"`
+	Attempts   int       `json:"attempts"`
+}
+
+// Bindings 记录domain绑定的设备，不同设备的注册请求进入审批队列
+type Bindings struct {
+	file      string
+	bindings  map[string]map[string]*Binding // tenant -> domain -> binding
+	approvals []*Approval
+	index     int
+	mut       sync.Mutex
+}
+
+func NewBindings(info BindingInfo) (*Bindings, error) {
+	if info.File == "" {
+		info.File = DefaultBindingFile
+	}
+	b := &Bindings{
+		file:     info.File,
+		bindings: make(map[string]map[string]*Binding),
+	}
+	if utils.FileExist(b.file) {
+		if err := utils.LoadJSONFile(b.file, &b.bindings); err != nil {
+			return nil, fmt.Errorf("load binding file failed: %v", err)
+		}
+	}
+	return b, nil
+}
+
+// Check 校验设备是否与domain绑定的设备一致，不一致时进入审批队列
+// domain没有绑定时直接通过，不做修改，由Bind在domain预留成功后绑定
+func (b *Bindings) Check(tenant, domain, mac, remote string) error {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.check(tenant, domain, parseMacs(mac), remote)
+}
+
+// Bind domain没有绑定时绑定到当前设备并保存，已经绑定时与Check相同
+// 在domain预留成功后调用，因domain冲突等原因被拒绝的连接不会占用绑定
+func (b *Bindings) Bind(tenant, domain, mac, remote string) error {
+	macs := parseMacs(mac)
+
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if _, found := b.bindings[tenant][domain]; found {
+		return b.check(tenant, domain, macs, remote)
+	}
+	if b.bindings[tenant] == nil {
+		b.bindings[tenant] = make(map[string]*Binding)
+	}
+	b.bindings[tenant][domain] = &Binding{Macs: macs, BoundTime: time.Now()}
+	log.Printf("domain bound to device. tenant='%v' domain='%v' macs=%v\n", tenant, domain, macs)
+	b.save()
+	return nil
+}
+
+func (b *Bindings) check(tenant, domain string, macs []string, remote string) error {
+	binding, found := b.bindings[tenant][domain]
+	if !found || sameDevice(binding.Macs, macs) {
+		return nil
+	}
+	b.queue(tenant, domain, macs, remote)
+	return errDeviceMismatch
+}
+
+// queue 记录审批请求，同一设备的重复请求只更新次数
+func (b *Bindings) queue(tenant, domain string, macs []string, remote string) {
+	now := time.Now()
+	key := strings.Join(macs, " ")
+	for _, a := range b.approvals {
+		if a.Tenant == tenant && a.Domain == domain && strings.Join(a.Macs, " ") == key {
+			a.RemoteAddr = remote
+			a.LastTime = now
+			a.Attempts++
+			return
+		}
+	}
+
+	b.index++
+	b.approvals = append(b.approvals, &Approval{
+		ID:         b.index,
+		Tenant:     tenant,
+		Domain:     domain,
+		Macs:       macs,
+		RemoteAddr: remote,
+		FirstTime:  now,
+		LastTime:   now,
+		Attempts:   1,
+	})
+	log.Printf("device mismatch, queued for approval. id=%v tenant='%v' domain='%v' macs=%v remote=%v\n",
+		b.index, tenant, domain, macs, remote)
+}
+
+// Approve 将domain重新绑定到审批请求中的设备，同一domain的其它请求一并移除
+func (b *Bindings) Approve(id int) (*Approval, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	a := b.remove(id)
+	if a == nil {
+		return nil, errors.New("approval not found")
+	}
+	list := b.approvals[:0]
+	for _, item := range b.approvals {
+		if item.Tenant != a.Tenant || item.Domain != a.Domain {
+			list = append(list, item)
+		}
+	}
+	b.approvals = list
+
+	if b.bindings[a.Tenant] == nil {
+		b.bindings[a.Tenant] = make(map[string]*Binding)
+	}
+	b.bindings[a.Tenant][a.Domain] = &Binding{Macs: a.Macs, BoundTime: time.Now()}
+	log.Printf("device approved. tenant='%v' domain='%v' macs=%v\n", a.Tenant, a.Domain, a.Macs)
+	b.save()
+	return a, nil
+}
+
+// Reject 移除审批请求，设备再次注册时会重新进入队列
+func (b *Bindings) Reject(id int) (*Approval, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	a := b.remove(id)
+	if a == nil {
+		return nil, errors.New("approval not found")
+	}
+	log.Printf("device rejected. tenant='%v' domain='%v' macs=%v\n", a.Tenant, a.Domain, a.Macs)
+	return a, nil
+}
+
+// Unbind 解除domain的绑定，下一次注册的设备会重新绑定
+func (b *Bindings) Unbind(tenant, domain string) bool {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if _, found := b.bindings[tenant][domain]; !found {
+		return false
+	}
+	delete(b.bindings[tenant], domain)
+	log.Printf("domain unbound. tenant='%v' domain='%v'\n", tenant, domain)
+	b.save()
+	return true
+}
+
+// List 返回所有绑定关系（按租户分组）
+func (b *Bindings) List() map[string]map[string]Binding {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	ret := make(map[string]map[string]Binding)
+	for tenant, domains := range b.bindings {
+		ret[tenant] = make(map[string]Binding)
+		for domain, binding := range domains {
+			ret[tenant][domain] = *binding
+		}
+	}
+	return ret
+}
+
+// Approvals 返回等待审批的请求，按请求时间排序
+func (b *Bindings) Approvals() []Approval {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	list := []Approval{}
+	for _, a := range b.approvals {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (b *Bindings) remove(id int) *Approval {
+	for i, a := range b.approvals {
+		if a.ID == id {
+			b.approvals = append(b.approvals[:i], b.approvals[i+1:]...)
+			return a
+		}
+	}
+	return nil
+}
+
+func (b *Bindings) save() {
+	data, err := json.MarshalIndent(b.bindings, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(b.file, data, 0644)
+	}
+	if err != nil {
+		log.Printf("save binding file failed: %v\n", err)
+	}
+}
+
+func parseMacs(mac string) []string {
+	return strings.Fields(strings.ToLower(mac))
+}
+
+// sameDevice 两组mac地址存在交集时认为是同一台设备
+// 虚拟网卡、VPN、docker等会随时增减网卡，要求完全一致会让同一台设备频繁进入审批队列
+// 因此只要有一个mac相同即可通过。mac由agent自行上报，可以伪造，绑定只用于发现domain被其它设备误用，不能代替密码
+func sameDevice(bound, macs []string) bool {
+	if len(bound) == 0 || len(macs) == 0 {
+		return len(bound) == len(macs)
+	}
+	for _, a := range bound {
+		for _, b := range macs {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
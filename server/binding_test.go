@@ -0,0 +1,164 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+func TestBindingsCheck(t *testing.T) {
+	fpath := filepath.Join(t.TempDir(), "bindings.json")
+	b, err := NewBindings(BindingInfo{Enable: true, File: fpath})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 没有绑定时Check直接通过，不会绑定设备
+	if err = b.Check(DefaultTenant, "pc", "bb:01", "1.1.1.1:1"); err != nil || len(b.List()) != 0 {
+		t.Errorf("check should not bind: %v %v", err, b.List())
+		return
+	}
+	if err = b.Bind(DefaultTenant, "pc", "AA:01 aa:02", "1.1.1.1:1"); err != nil {
+		t.Errorf("first device should be bound: %v", err)
+		return
+	}
+	// 任意一个mac相同即认为是同一台设备
+	if err = b.Check(DefaultTenant, "pc", "aa:02 aa:03", "1.1.1.1:2"); err != nil {
+		t.Errorf("same device should be accepted: %v", err)
+	}
+	// 不同租户的domain互不影响
+	if err = b.Bind("team", "pc", "bb:01", "2.2.2.2:1"); err != nil {
+		t.Errorf("other tenant should be bound separately: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = b.Bind(DefaultTenant, "pc", "cc:01", "3.3.3.3:1"); err != errDeviceMismatch {
+			t.Errorf("other device should be rejected: %v", err)
+			return
+		}
+	}
+	approvals := b.Approvals()
+	if len(approvals) != 1 || approvals[0].Attempts != 2 {
+		t.Errorf("unexpected approvals: %+v", approvals)
+		return
+	}
+
+	if _, err = b.Approve(approvals[0].ID); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(b.Approvals()) != 0 {
+		t.Error("approval should be removed")
+	}
+
+	// 重新加载后绑定关系保持不变
+	b, err = NewBindings(BindingInfo{Enable: true, File: fpath})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if b.Check(DefaultTenant, "pc", "cc:01", "3.3.3.3:1") != nil {
+		t.Error("approved device should be accepted")
+	}
+	if b.Check(DefaultTenant, "pc", "aa:01", "1.1.1.1:1") != errDeviceMismatch {
+		t.Error("old device should be rejected after approval")
+	}
+}
+
+// 并发握手同一个domain时只有一个能通过
+func TestRelayAdmitConcurrent(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tenant := relay.Tenant(DefaultTenant)
+
+	var wg sync.WaitGroup
+	var admitted int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := switcher.Request{Domain: "pc", Mac: "mac"}
+			if relay.admit(tenant, &req, "1.1.1.1:1") == nil {
+				atomic.AddInt32(&admitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if admitted != 1 {
+		t.Errorf("expect exactly one admitted, got %v", admitted)
+		return
+	}
+
+	// 登记会话后预留转为会话，会话关闭后可以重新接入
+	req := switcher.Request{Domain: "pc", Mac: "mac"}
+	c1, _ := packet.Pipe()
+	s := newSession(relay, tenant, c1, nil, &req, "1.1.1.1:1", "pipe")
+	relay.attach(s)
+	if relay.admit(tenant, &req, "1.1.1.1:2") != errDomainOnline {
+		t.Error("attached session should block the domain")
+	}
+	s.closeWith("kicked")
+	relay.detach(s)
+	if err = relay.admit(tenant, &req, "1.1.1.1:2"); err != nil {
+		t.Error("domain should be free after detach", err)
+	}
+}
+
+// 因domain在线被拒绝的设备不能占用domain的绑定
+func TestRelayAdmitBindAfterReserve(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{
+		Password: "pswd",
+		Binding:  BindingInfo{Enable: true, File: filepath.Join(t.TempDir(), "bindings.json")},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tenant := relay.Tenant(DefaultTenant)
+
+	// 启用绑定之前已经在线的会话
+	reqA := switcher.Request{Domain: "pc", Mac: "aa:01"}
+	c1, _ := packet.Pipe()
+	s := newSession(relay, tenant, c1, nil, &reqA, "1.1.1.1:1", "pipe")
+	relay.attach(s)
+
+	reqB := switcher.Request{Domain: "pc", Mac: "bb:01"}
+	if err = relay.admit(tenant, &reqB, "2.2.2.2:1"); err != errDomainOnline {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if len(relay.bindings.List()[DefaultTenant]) != 0 {
+		t.Errorf("rejected device should not be bound: %v", relay.bindings.List())
+		return
+	}
+
+	s.closeWith("kicked")
+	relay.detach(s)
+	if err = relay.admit(tenant, &reqA, "1.1.1.1:2"); err != nil {
+		t.Error(err)
+		return
+	}
+	if macs := relay.bindings.List()[DefaultTenant]["pc"].Macs; len(macs) != 1 || macs[0] != "aa:01" {
+		t.Errorf("unexpected binding: %v", macs)
+		return
+	}
+
+	// 绑定失败时释放预留
+	relay.release(tenant, "pc")
+	if err = relay.admit(tenant, &reqB, "2.2.2.2:2"); err != errDeviceMismatch {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	if err = relay.admit(tenant, &reqA, "1.1.1.1:3"); err != nil {
+		t.Errorf("reservation should be released after mismatch: %v", err)
+		return
+	}
+}
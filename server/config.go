@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"path"
+	"strings"
+
 	"github.com/net-agent/remotework/utils"
 )
 
@@ -15,12 +19,82 @@ type ServerInfo struct {
 	Password string `json:"password" toml:"password"` // 校验连接的密码
 	WsEnable bool   `json:"wsEnable" toml:"wsEnable"` // 是否启用Websocket
 	WsPath   string `json:"wsPath" toml:"wsPath"`     // Websocket路径
+
+	WsAuth WsAuthInfo `json:"wsAuth" toml:"wsAuth"` // websocket升级前的token与Origin校验
+
+	Credentials     map[string]string    `json:"credentials" toml:"credentials"`         // domain独立密码，优先于password
+	CredentialsFile string               `json:"credentialsFile" toml:"credentialsFile"` // domain密码文件（json或toml）
+	PolicyFile      string               `json:"policyFile" toml:"policyFile"`           // domain之间的访问控制策略文件（json或toml）
+	Limits          map[string]LimitInfo `json:"limits" toml:"limits"`                   // domain带宽限制与配额，"*"为默认配置
+	Tenants         []TenantInfo         `json:"tenants" toml:"tenants"`                 // 租户列表，以上配置与password组成default租户
+
+	AllowIPs []string `json:"allowIPs" toml:"allowIPs"` // 允许连接的ip或CIDR，为空时不限制
+	DenyIPs  []string `json:"denyIPs" toml:"denyIPs"`   // 禁止连接的ip或CIDR，优先于allowIPs
+	Ban      BanInfo  `json:"ban" toml:"ban"`           // 握手校验失败的封禁配置
+
+	TrustedProxies []string `json:"trustedProxies" toml:"trustedProxies"` // 受信任的反向代理（ip或CIDR），websocket连接从proxyHeader中获取真实地址
+	ProxyHeader    string   `json:"proxyHeader" toml:"proxyHeader"`       // 携带真实地址的header，默认X-Forwarded-For
+
+	DomainConflict string      `json:"domainConflict" toml:"domainConflict"` // domain已经在线时的处理：reject（默认，拒绝新连接）或 kick（断开旧连接）
+	Binding        BindingInfo `json:"binding" toml:"binding"`               // domain与设备的绑定
+
+	TLS   TLSInfo   `json:"tls" toml:"tls"`     // flex与http监听的TLS配置
+	Admin AdminInfo `json:"admin" toml:"admin"` // 管理接口
+	Audit AuditInfo `json:"audit" toml:"audit"` // 连接审计日志
+
+	Metrics MetricsInfo `json:"metrics" toml:"metrics"` // Prometheus指标接口
+
+	DrainTimeout int    `json:"drainTimeout" toml:"drainTimeout"` // 停机时等待活跃连接结束的秒数，默认30，小于0代表不等待
+	LogFile      string `json:"logFile" toml:"logFile"`           // 日志文件（追加写入），为空时输出到stderr
 }
 
-func NewConfig(jsonfile string) (*Config, error) {
-	cfg := &Config{}
+// DefaultTenantInfo 顶层的密码与策略配置，作为default租户
+func (info *ServerInfo) DefaultTenantInfo() TenantInfo {
+	return TenantInfo{
+		Name:            DefaultTenant,
+		Password:        info.Password,
+		Credentials:     info.Credentials,
+		CredentialsFile: info.CredentialsFile,
+		PolicyFile:      info.PolicyFile,
+		Limits:          info.Limits,
+	}
+}
+
+func (info *ServerInfo) checkConflict() error {
+	if info.DomainConflict != "" && info.DomainConflict != ConflictReject && info.DomainConflict != ConflictKick {
+		return fmt.Errorf("invalid domain conflict policy '%v'", info.DomainConflict)
+	}
+	return nil
+}
+
+// TenantInfos 返回按校验顺序排列的租户配置，default租户放在最后
+func (info *ServerInfo) TenantInfos() ([]TenantInfo, error) {
+	infos := append([]TenantInfo{}, info.Tenants...)
+	if len(infos) == 0 || info.Password != "" || len(info.Credentials) > 0 || info.CredentialsFile != "" {
+		infos = append(infos, info.DefaultTenantInfo())
+	}
 
-	err := utils.LoadJSONFile(jsonfile, cfg)
+	names := make(map[string]bool)
+	for _, ti := range infos {
+		if names[ti.Name] {
+			return nil, fmt.Errorf("tenant '%v' exists", ti.Name)
+		}
+		names[ti.Name] = true
+	}
+	return infos, nil
+}
+
+func NewConfig(configFileName string) (*Config, error) {
+	cfg := &Config{}
+	var err error
+	switch strings.ToLower(path.Ext(configFileName)) {
+	case ".json":
+		err = utils.LoadJSONFile(configFileName, cfg)
+	case ".toml":
+		err = utils.LoadTomlFile(configFileName, cfg)
+	default:
+		err = fmt.Errorf("config file [%s] not support, must be json or toml", configFileName)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/net-agent/flex/v2/switcher"
+	"github.com/net-agent/remotework/utils"
+)
+
+// Credentials 按domain校验握手密码
+// 密码支持明文，或者 "sha256:<hex>" 格式的摘要（agent需开启passwordHash）
+// 摘要直接作为握手密码使用，不对保存的密码提供任何保护，只避免原始密码以明文出现，需要按明文密码同等保管
+// 未配置的domain使用共享密码进行校验，共享密码为空时直接拒绝
+type Credentials struct {
+	shared  string
+	domains map[string]string
+}
+
+func NewCredentials(shared string, entries map[string]string, fpath string) (*Credentials, error) {
+	creds := &Credentials{
+		shared:  shared,
+		domains: make(map[string]string),
+	}
+
+	if fpath != "" {
+		var fileEntries map[string]string
+		var err error
+		switch strings.ToLower(path.Ext(fpath)) {
+		case ".toml":
+			err = utils.LoadTomlFile(fpath, &fileEntries)
+		default:
+			err = utils.LoadJSONFile(fpath, &fileEntries)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("load credentials file failed: %v", err)
+		}
+		for domain, pswd := range fileEntries {
+			creds.domains[strings.ToLower(domain)] = pswd
+		}
+	}
+
+	// 配置文件中的内容优先级更高
+	for domain, pswd := range entries {
+		creds.domains[strings.ToLower(domain)] = pswd
+	}
+
+	return creds, nil
+}
+
+// Verify 校验握手请求的签名
+func (creds *Credentials) Verify(req *switcher.Request) bool {
+	pswd, found := creds.domains[strings.ToLower(req.Domain)]
+	if !found {
+		if creds.shared == "" {
+			return false
+		}
+		pswd = creds.shared
+	}
+
+	sum := req.CalcSum(pswd)
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(req.Sum)) == 1
+}
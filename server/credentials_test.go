@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/switcher"
+	"github.com/net-agent/remotework/utils"
+)
+
+func TestCredentialsVerify(t *testing.T) {
+	creds, err := NewCredentials("shared", map[string]string{
+		"Test_Agent": "agent-pswd",
+		"hashed":     utils.HashPassword("hash-pswd"),
+	}, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		domain string
+		pswd   string
+		ok     bool
+	}{
+		{"controller", "shared", true},
+		{"controller", "agent-pswd", false},
+		{"test_agent", "agent-pswd", true},
+		{"TEST_AGENT", "agent-pswd", true},
+		{"test_agent", "shared", false},
+		{"hashed", "hash-pswd", false},
+		{"hashed", utils.HashPassword("hash-pswd"), true},
+	}
+
+	for index, c := range cases {
+		req := switcher.Request{Domain: c.domain, Mac: "mac", Timestamp: time.Now().UnixNano()}
+		req.Sum = req.CalcSum(c.pswd)
+		if creds.Verify(&req) != c.ok {
+			t.Errorf("verify failed, index=%v domain='%v'", index, c.domain)
+			return
+		}
+	}
+
+	// 未设置共享密码时，未配置的domain一律拒绝
+	creds, err = NewCredentials("", map[string]string{"test_agent": "agent-pswd"}, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, domain := range []string{"test_agent", "controller"} {
+		req := switcher.Request{Domain: domain, Mac: "mac", Timestamp: time.Now().UnixNano()}
+		req.Sum = req.CalcSum("")
+		if creds.Verify(&req) {
+			t.Errorf("empty password accepted, domain='%v'", domain)
+			return
+		}
+	}
+}
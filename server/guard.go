@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultProxyHeader = "X-Forwarded-For"
+	DefaultMaxFailures = 5
+	DefaultFailWindow  = 60  // 秒
+	DefaultBanTime     = 600 // 秒
+)
+
+var (
+	errIPDenied = errors.New("ip denied")
+	errIPBanned = errors.New("ip banned")
+)
+
+// BanInfo 握手校验失败的封禁配置
+type BanInfo struct {
+	MaxFailures int `json:"maxFailures" toml:"maxFailures"` // window内失败次数达到该值后封禁，默认5，小于0代表不封禁
+	Window      int `json:"window" toml:"window"`           // 统计失败次数的时间窗口（秒），默认60
+	BanTime     int `json:"banTime" toml:"banTime"`         // 封禁时长（秒），默认600
+}
+
+func (info BanInfo) normalize() BanInfo {
+	if info.MaxFailures == 0 {
+		info.MaxFailures = DefaultMaxFailures
+	}
+	if info.Window <= 0 {
+		info.Window = DefaultFailWindow
+	}
+	if info.BanTime <= 0 {
+		info.BanTime = DefaultBanTime
+	}
+	return info
+}
+
+// BanReport 封禁信息
+type BanReport struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// Guard 在握手之前根据来源ip进行过滤，并封禁多次校验失败的ip
+// 受信任的代理地址不会被封禁，通过代理的websocket连接使用header中的真实地址
+type Guard struct {
+	allow   []*net.IPNet // 为空时允许所有ip
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+	header  string
+	info    BanInfo
+
+	failures map[string][]time.Time
+	bans     map[string]*BanReport
+	mut      sync.Mutex
+}
+
+func NewGuard(info ServerInfo) (*Guard, error) {
+	g := &Guard{
+		failures: make(map[string][]time.Time),
+		bans:     make(map[string]*BanReport),
+	}
+	if err := g.Update(info); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// guardRules 解析后的名单与封禁配置，热加载时先解析，其它配置全部校验通过后再生效
+type guardRules struct {
+	allow   []*net.IPNet
+	deny    []*net.IPNet
+	proxies []*net.IPNet
+	header  string
+	info    BanInfo
+}
+
+func parseGuardRules(info ServerInfo) (*guardRules, error) {
+	allow, err := parseCIDRs(info.AllowIPs)
+	if err != nil {
+		return nil, fmt.Errorf("allowIPs: %v", err)
+	}
+	deny, err := parseCIDRs(info.DenyIPs)
+	if err != nil {
+		return nil, fmt.Errorf("denyIPs: %v", err)
+	}
+	proxies, err := parseCIDRs(info.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trustedProxies: %v", err)
+	}
+	header := info.ProxyHeader
+	if header == "" {
+		header = DefaultProxyHeader
+	}
+	return &guardRules{allow: allow, deny: deny, proxies: proxies, header: header, info: info.Ban.normalize()}, nil
+}
+
+// Update 更新名单与封禁配置，已有的封禁保持不变
+func (g *Guard) Update(info ServerInfo) error {
+	rules, err := parseGuardRules(info)
+	if err != nil {
+		return err
+	}
+	g.apply(rules)
+	return nil
+}
+
+func (g *Guard) apply(rules *guardRules) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	g.allow = rules.allow
+	g.deny = rules.deny
+	g.proxies = rules.proxies
+	g.header = rules.header
+	g.info = rules.info
+}
+
+// Trusted 来源地址是否为受信任的代理
+func (g *Guard) Trusted(addr string) bool {
+	ip := net.ParseIP(hostOf(addr))
+	if ip == nil {
+		return false
+	}
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return containsIP(g.proxies, ip)
+}
+
+// ClientAddr 返回请求的真实来源地址
+// 只有直接来源是受信任的代理时才读取header，从右向左跳过受信任的代理，取第一个地址
+func (g *Guard) ClientAddr(r *http.Request) string {
+	if !g.Trusted(r.RemoteAddr) {
+		return r.RemoteAddr
+	}
+
+	g.mut.Lock()
+	header, proxies := g.header, g.proxies
+	g.mut.Unlock()
+
+	list := strings.Split(r.Header.Get(header), ",")
+	for i := len(list) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hostOf(strings.TrimSpace(list[i])))
+		if ip == nil {
+			break
+		}
+		if !containsIP(proxies, ip) {
+			return ip.String()
+		}
+	}
+	return r.RemoteAddr
+}
+
+// Check 判断来源地址是否允许连接
+func (g *Guard) Check(addr string) error {
+	host := hostOf(addr)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errIPDenied
+	}
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if containsIP(g.deny, ip) {
+		return errIPDenied
+	}
+	if len(g.allow) > 0 && !containsIP(g.allow, ip) {
+		return errIPDenied
+	}
+	if ban, found := g.bans[host]; found {
+		if time.Now().Before(ban.Until) {
+			return errIPBanned
+		}
+		delete(g.bans, host)
+	}
+	return nil
+}
+
+// Fail 记录一次校验失败，达到次数后封禁该ip
+func (g *Guard) Fail(addr string) {
+	host := hostOf(addr)
+
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.info.MaxFailures < 0 {
+		return
+	}
+	// 封禁代理会影响代理之后的所有agent
+	if ip := net.ParseIP(host); ip != nil && containsIP(g.proxies, ip) {
+		return
+	}
+
+	now := time.Now()
+	window := time.Duration(g.info.Window) * time.Second
+	if len(g.failures) > 1024 {
+		g.sweep(now, window)
+	}
+
+	list := append(recent(g.failures[host], now, window), now)
+	if len(list) < g.info.MaxFailures {
+		g.failures[host] = list
+		return
+	}
+
+	delete(g.failures, host)
+	until := now.Add(time.Duration(g.info.BanTime) * time.Second)
+	g.bans[host] = &BanReport{IP: host, Failures: len(list), Until: until}
+	log.Printf("ip banned. ip=%v failures=%v until=%v\n", host, len(list), until.Format(time.RFC3339))
+}
+
+// Success 校验成功后清除该ip的失败记录
+func (g *Guard) Success(addr string) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	delete(g.failures, hostOf(addr))
+}
+
+// Bans 返回生效中的封禁列表，按解封时间排序
+func (g *Guard) Bans() []BanReport {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	now := time.Now()
+	list := []BanReport{}
+	for host, ban := range g.bans {
+		if now.After(ban.Until) {
+			delete(g.bans, host)
+			continue
+		}
+		list = append(list, *ban)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Until.Before(list[j].Until)
+	})
+	return list
+}
+
+// Unban 解除封禁，返回ip是否处于封禁中
+func (g *Guard) Unban(ip string) bool {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	_, found := g.bans[ip]
+	delete(g.bans, ip)
+	delete(g.failures, ip)
+	if found {
+		log.Printf("ip unbanned. ip=%v\n", ip)
+	}
+	return found
+}
+
+// checkAccept 在TLS握手之前检查来源地址，受信任的代理在读取请求后使用真实地址检查
+func (relay *Relay) checkAccept(addr string) error {
+	if relay.guard.Trusted(addr) {
+		return nil
+	}
+	return relay.checkRemote(addr)
+}
+
+// checkRemote 在握手之前检查来源地址
+func (relay *Relay) checkRemote(addr string) error {
+	err := relay.guard.Check(addr)
+	if err != nil {
+		atomic.AddInt64(&relay.stats.refused, 1)
+	}
+	return err
+}
+
+// sweep 清理过期的失败记录，避免大量扫描请求占用内存
+func (g *Guard) sweep(now time.Time, window time.Duration) {
+	for host, list := range g.failures {
+		list = recent(list, now, window)
+		if len(list) == 0 {
+			delete(g.failures, host)
+		} else {
+			g.failures[host] = list
+		}
+	}
+}
+
+func recent(list []time.Time, now time.Time, window time.Duration) []time.Time {
+	for len(list) > 0 && now.Sub(list[0]) > window {
+		list = list[1:]
+	}
+	return list
+}
+
+// parseCIDRs 解析CIDR列表，也支持单个ip
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, str := range list {
+		str = strings.TrimSpace(str)
+		if !strings.Contains(str, "/") {
+			ip := net.ParseIP(str)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip '%v'", str)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(str)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr '%v'", str)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf 去掉地址中的端口
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
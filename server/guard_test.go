@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+func TestGuardCheck(t *testing.T) {
+	g, err := NewGuard(ServerInfo{
+		AllowIPs: []string{"10.0.0.0/8", "192.168.1.10", "fd00::/8"},
+		DenyIPs:  []string{"10.1.0.0/16"},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		addr string
+		err  error
+	}{
+		{"10.0.0.1:1234", nil},
+		{"10.1.0.1:1234", errIPDenied},
+		{"192.168.1.10:1234", nil},
+		{"192.168.1.11:1234", errIPDenied},
+		{"[fd00::1]:1234", nil},
+		{"[fe80::1]:1234", errIPDenied},
+		{"invalid", errIPDenied},
+	}
+	for index, c := range cases {
+		if err := g.Check(c.addr); err != c.err {
+			t.Errorf("check failed, index=%v addr=%v err=%v", index, c.addr, err)
+		}
+	}
+
+	if _, err := NewGuard(ServerInfo{DenyIPs: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("invalid cidr should be rejected")
+	}
+}
+
+func TestGuardBan(t *testing.T) {
+	g, err := NewGuard(ServerInfo{Ban: BanInfo{MaxFailures: 3}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	addr := "1.2.3.4:5678"
+	g.Fail(addr)
+	g.Fail(addr)
+	g.Success(addr) // 成功后重新计数
+	g.Fail(addr)
+	g.Fail(addr)
+	if err := g.Check(addr); err != nil {
+		t.Errorf("should not be banned yet: %v", err)
+		return
+	}
+
+	g.Fail(addr)
+	if err := g.Check(addr); err != errIPBanned {
+		t.Errorf("should be banned: %v", err)
+		return
+	}
+	if err := g.Check("1.2.3.5:5678"); err != nil {
+		t.Errorf("other ip should not be banned: %v", err)
+	}
+
+	bans := g.Bans()
+	if len(bans) != 1 || bans[0].IP != "1.2.3.4" || bans[0].Failures != 3 {
+		t.Errorf("unexpected bans: %v", bans)
+		return
+	}
+	if !g.Unban("1.2.3.4") || g.Check(addr) != nil {
+		t.Error("unban failed")
+	}
+}
+
+func TestGuardTrustedProxy(t *testing.T) {
+	g, err := NewGuard(ServerInfo{
+		DenyIPs:        []string{"203.0.113.7"},
+		TrustedProxies: []string{"10.0.0.0/8"},
+		Ban:            BanInfo{MaxFailures: 1},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	cases := []struct {
+		remote string
+		header string
+		client string
+	}{
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "1.1.1.1, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1:1234"},
+		{"10.0.0.1:1234", "invalid", "10.0.0.1:1234"},
+		// 不是受信任的代理时忽略header
+		{"192.0.2.1:1234", "198.51.100.1", "192.0.2.1:1234"},
+	}
+	for index, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		r.Header.Set("X-Forwarded-For", c.header)
+		if client := g.ClientAddr(r); client != c.client {
+			t.Errorf("unexpected client, index=%v client=%v", index, client)
+		}
+	}
+
+	// 代理地址不会被封禁，真实地址正常封禁与过滤
+	g.Fail("10.0.0.1:1234")
+	if err = g.Check("10.0.0.1:1234"); err != nil {
+		t.Error("trusted proxy should not be banned", err)
+	}
+	g.Fail("198.51.100.1")
+	if err = g.Check("198.51.100.1"); err != errIPBanned {
+		t.Error("client behind proxy should be banned", err)
+	}
+	if err = g.Check("203.0.113.7"); err != errIPDenied {
+		t.Error("client behind proxy should be denied", err)
+	}
+}
+
+// agent根据 "invalid checksum" 识别密码错误，延长重连等待
+func TestRelayAuthFailedMessage(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	c1, c2 := packet.Pipe()
+	go relay.ServeConn(c2, "1.1.1.1:1", "pipe")
+	_, err = switcher.UpgradeToNode(c1, "pc", "mac", "wrong")
+	if err == nil || !strings.Contains(err.Error(), "invalid checksum") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
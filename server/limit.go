@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	QuotaDisconnect = "disconnect"
+	QuotaThrottle   = "throttle"
+)
+
+// LimitInfo domain的带宽限制与流量配额，单位均为字节，0代表不限制
+type LimitInfo struct {
+	Rate         int64  `json:"rate" toml:"rate"`                 // 每秒字节数，上下行分别计算
+	Burst        int64  `json:"burst" toml:"burst"`               // 令牌桶容量，默认等于rate
+	Daily        int64  `json:"daily" toml:"daily"`               // 每日流量配额（上下行合计）
+	Monthly      int64  `json:"monthly" toml:"monthly"`           // 每月流量配额（上下行合计）
+	QuotaAction  string `json:"quotaAction" toml:"quotaAction"`   // 超出配额后的动作：disconnect（默认）或 throttle
+	ThrottleRate int64  `json:"throttleRate" toml:"throttleRate"` // throttle时的速率
+}
+
+func (info *LimitInfo) check() error {
+	if info.QuotaAction == "" {
+		info.QuotaAction = QuotaDisconnect
+	}
+	if info.QuotaAction != QuotaDisconnect && info.QuotaAction != QuotaThrottle {
+		return errors.New("invalid quota action: " + info.QuotaAction)
+	}
+	if info.QuotaAction == QuotaThrottle && info.ThrottleRate <= 0 {
+		return errors.New("throttle rate must be positive")
+	}
+	return nil
+}
+
+// TokenBucket 令牌桶，允许短时间透支，透支部分通过等待偿还
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mut    sync.Mutex
+}
+
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	b := &TokenBucket{last: time.Now()}
+	b.SetRate(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+// SetRate 修改速率，rate<=0代表不限制
+func (b *TokenBucket) SetRate(rate, burst int64) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+
+	if burst <= 0 {
+		burst = rate
+	}
+	b.rate = float64(rate)
+	b.burst = float64(burst)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Wait 取出n个令牌，令牌不足时阻塞等待
+func (b *TokenBucket) Wait(n int) {
+	b.mut.Lock()
+	if b.rate <= 0 {
+		b.mut.Unlock()
+		return
+	}
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mut.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Limiter domain的限速器与配额统计，同一domain的多个会话共享
+type Limiter struct {
+	info LimitInfo
+	up   *TokenBucket
+	down *TokenBucket
+
+	day       string
+	dayUsed   int64
+	month     string
+	monthUsed int64
+	exceeded  bool
+	mut       sync.Mutex
+}
+
+// UsageReport 配额使用情况
+type UsageReport struct {
+	Rate        int64 `json:"rate"`
+	Daily       int64 `json:"daily"`
+	DailyUsed   int64 `json:"dailyUsed"`
+	Monthly     int64 `json:"monthly"`
+	MonthlyUsed int64 `json:"monthlyUsed"`
+	Exceeded    bool  `json:"exceeded"`
+}
+
+func NewLimiter(info LimitInfo) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		info:  info,
+		up:    NewTokenBucket(info.Rate, info.Burst),
+		down:  NewTokenBucket(info.Rate, info.Burst),
+		day:   now.Format("2006-01-02"),
+		month: now.Format("2006-01"),
+	}
+}
+
+// Consume 记录n个字节的流量，wait为true时按速率等待，返回是否需要断开连接
+func (l *Limiter) Consume(n int, upload, wait bool) (disconnect bool) {
+	if wait && upload {
+		l.up.Wait(n)
+	} else if wait {
+		l.down.Wait(n)
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.rotate(time.Now())
+	l.dayUsed += int64(n)
+	l.monthUsed += int64(n)
+
+	if !l.exceeded && l.overQuota() {
+		l.exceeded = true
+		if l.info.QuotaAction == QuotaThrottle {
+			l.up.SetRate(l.info.ThrottleRate, 0)
+			l.down.SetRate(l.info.ThrottleRate, 0)
+		}
+	}
+	return l.exceeded && l.info.QuotaAction == QuotaDisconnect
+}
+
+// Rejected 配额已用尽并且动作为disconnect时，拒绝新的连接
+func (l *Limiter) Rejected() bool {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.rotate(time.Now())
+	return l.exceeded && l.info.QuotaAction == QuotaDisconnect
+}
+
+// Update 更新限制配置，保留已经统计的用量
+func (l *Limiter) Update(info LimitInfo) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.rotate(time.Now())
+	l.info = info
+	l.exceeded = l.overQuota()
+	if l.exceeded && info.QuotaAction == QuotaThrottle {
+		l.up.SetRate(info.ThrottleRate, 0)
+		l.down.SetRate(info.ThrottleRate, 0)
+	} else {
+		l.up.SetRate(info.Rate, info.Burst)
+		l.down.SetRate(info.Rate, info.Burst)
+	}
+}
+
+func (l *Limiter) Report() UsageReport {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.rotate(time.Now())
+	return UsageReport{
+		Rate:        l.info.Rate,
+		Daily:       l.info.Daily,
+		DailyUsed:   l.dayUsed,
+		Monthly:     l.info.Monthly,
+		MonthlyUsed: l.monthUsed,
+		Exceeded:    l.exceeded,
+	}
+}
+
+func (l *Limiter) overQuota() bool {
+	return (l.info.Daily > 0 && l.dayUsed >= l.info.Daily) ||
+		(l.info.Monthly > 0 && l.monthUsed >= l.info.Monthly)
+}
+
+// rotate 跨天或跨月时重置统计，并恢复正常速率
+func (l *Limiter) rotate(now time.Time) {
+	day := now.Format("2006-01-02")
+	if day == l.day {
+		return
+	}
+	l.day = day
+	l.dayUsed = 0
+
+	month := now.Format("2006-01")
+	if month != l.month {
+		l.month = month
+		l.monthUsed = 0
+	}
+
+	if l.exceeded && !l.overQuota() {
+		l.exceeded = false
+		l.up.SetRate(l.info.Rate, l.info.Burst)
+		l.down.SetRate(l.info.Rate, l.info.Burst)
+	}
+}
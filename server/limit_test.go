@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	b := NewTokenBucket(1000, 100)
+
+	start := time.Now()
+	b.Wait(100) // 消耗初始容量
+	b.Wait(200)
+	dur := time.Since(start)
+	if dur < time.Millisecond*150 || dur > time.Millisecond*500 {
+		t.Error("unexpected wait duration", dur)
+		return
+	}
+
+	b.SetRate(0, 0)
+	start = time.Now()
+	b.Wait(1 << 20)
+	if time.Since(start) > time.Millisecond*10 {
+		t.Error("unlimited bucket should not wait")
+		return
+	}
+}
+
+func TestLimiterQuota(t *testing.T) {
+	l := NewLimiter(LimitInfo{Daily: 100, QuotaAction: QuotaDisconnect})
+	if l.Consume(60, true, true) {
+		t.Error("unexpected disconnect")
+		return
+	}
+	if !l.Consume(60, false, true) {
+		t.Error("disconnect expected")
+		return
+	}
+	if !l.Rejected() {
+		t.Error("reject expected")
+		return
+	}
+
+	// 跨天后恢复
+	l.rotate(time.Now().AddDate(0, 0, 1))
+	if l.Rejected() || l.Report().DailyUsed != 0 {
+		t.Error("quota should be reset")
+		return
+	}
+}
+
+func TestLimiterThrottle(t *testing.T) {
+	l := NewLimiter(LimitInfo{Monthly: 10, QuotaAction: QuotaThrottle, ThrottleRate: 1000})
+	if l.Consume(20, true, true) {
+		t.Error("throttle should not disconnect")
+		return
+	}
+	if !l.Report().Exceeded || l.Rejected() {
+		t.Error("exceeded without reject expected")
+		return
+	}
+	if l.up.rate != 1000 || l.down.rate != 1000 {
+		t.Error("throttle rate not applied")
+		return
+	}
+}
+
+// 限速domain的下行积压不能拖慢其它domain之间的转发
+func TestThrottleIsolation(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{
+		Password: "pswd",
+		Limits:   map[string]LimitInfo{"slow": {Rate: 1024}},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	connect := func(domain string) *node.Node {
+		c1, c2 := packet.Pipe()
+		go relay.ServeConn(c2, domain, "pipe")
+		n, err := switcher.UpgradeToNode(c1, domain, "mac-"+domain, "pswd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.SetDomain(domain)
+		go n.Run()
+		return n
+	}
+	a := connect("a")
+	b := connect("b")
+	slow := connect("slow")
+	defer a.Close()
+	defer b.Close()
+	defer slow.Close()
+
+	slowListener, err := slow.Listen(80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for {
+			c, err := slowListener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, c)
+		}
+	}()
+	bListener, err := b.Listen(80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		for {
+			c, err := bListener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	// 向slow发送远超速率的数据，在1KB/s的限速下需要很长时间才能写完
+	sc, err := a.DialDomain("slow", 80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer sc.Close()
+	go sc.Write(make([]byte, 256<<10))
+	time.Sleep(time.Millisecond * 200)
+
+	start := time.Now()
+	bc, err := a.DialDomain("b", 80)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer bc.Close()
+	msg := []byte("hello")
+	if _, err = bc.Write(msg); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(bc, buf); err != nil || string(buf) != string(msg) {
+		t.Error("echo failed", err)
+		return
+	}
+	if dur := time.Since(start); dur > time.Second {
+		t.Error("traffic between a and b delayed by throttled domain", dur)
+	}
+}
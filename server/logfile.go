@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+var logFile struct {
+	path string
+	file *os.File
+	mut  sync.Mutex
+}
+
+// SetLogFile 将日志输出到文件（追加写入），路径为空时输出到stderr
+func SetLogFile(fpath string) error {
+	logFile.mut.Lock()
+	defer logFile.mut.Unlock()
+	return openLogFile(fpath)
+}
+
+// ReopenLogFile 重新打开日志文件，配合logrotate等工具使用
+func ReopenLogFile() error {
+	logFile.mut.Lock()
+	defer logFile.mut.Unlock()
+	if logFile.path == "" {
+		return nil
+	}
+	return openLogFile(logFile.path)
+}
+
+func openLogFile(fpath string) error {
+	var w io.Writer = os.Stderr
+	var f *os.File
+	if fpath != "" {
+		var err error
+		f, err = os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	log.SetOutput(w)
+	if logFile.file != nil {
+		logFile.file.Close()
+	}
+	logFile.path = fpath
+	logFile.file = f
+	return nil
+}
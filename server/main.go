@@ -1,11 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
 	"log"
+	"net"
+	"os"
+	"os/signal"
 	"path"
 	"sync"
+	"syscall"
+	"time"
 
-	"github.com/net-agent/flex/v2/switcher"
 	"github.com/net-agent/mixlisten"
 	"github.com/net-agent/remotework/utils"
 )
@@ -35,44 +40,88 @@ func main() {
 		log.Fatal("load config failed: ", err)
 	}
 
+	if err = SetLogFile(config.Server.LogFile); err != nil {
+		log.Fatal("open log file failed: ", err)
+	}
+
 	// 初始化
-	app := switcher.NewServer(config.Server.Password)
+	relay, err := NewRelay(config.Server)
+	if err != nil {
+		log.Fatal("init relay failed: ", err)
+	}
+	relay.configFile = configName
+
+	var tlsConfig *tls.Config
+	if config.Server.TLS.Enable {
+		tlsConfig, err = NewTLSConfig(config.Server.TLS)
+		if err != nil {
+			log.Fatal("load tls config failed: ", err)
+		}
+	}
 
 	log.Printf("try to listen on '%v'\n", config.Server.Listen)
 
 	// 监听本地端口（混合协议模式）
-	mxl := mixlisten.Listen("tcp", config.Server.Listen)
-	mxl.RegisterBuiltIn("flex", "http")
+	l, err := net.Listen("tcp", config.Server.Listen)
+	if err != nil {
+		log.Fatal("listen failed: ", err)
+	}
+	flexListener := closeOnce(mixlisten.Flex())
+	httpListener := closeOnce(mixlisten.HTTP())
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		mxl.Run()
+		mixServe(l, relay.checkAccept, tlsConfig, config.Server.TLS.AllowPlain, flexListener, httpListener)
+		// 入口停止后关闭各协议的listener，ServeTCP与ServeWs随之退出
+		flexListener.Close()
+		httpListener.Close()
 		wg.Done()
 	}()
 
 	// 处理Flex协议监听
-	flexListener, err := mxl.GetListener("flex")
-	if err != nil {
-		log.Fatal("get flex listener failed: ", err)
-	}
 	wg.Add(1)
 	go func() {
-		ServeTCP(app, config.Server, flexListener)
+		ServeTCP(relay, config.Server, flexListener)
 		wg.Done()
 	}()
 
 	// 处理HTTP协议监听
-	httpListener, err := mxl.GetListener("http")
-	if err != nil {
-		log.Fatal("get http listener failed: ", err)
-	}
 	wg.Add(1)
 	go func() {
-		ServeWs(app, config.Server, httpListener)
+		ServeWs(relay, config.Server, httpListener)
 		wg.Done()
 	}()
 
+	// 处理信号：SIGHUP重新加载配置；SIGINT、SIGTERM停止接入新的agent，等待活跃连接结束后退出
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var sig os.Signal
+	for sig = range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		log.Printf("received signal '%v', reloading config from '%v'\n", sig, configName)
+		if _, err := relay.ReloadConfig(); err != nil {
+			log.Printf("reload config failed: %v\n", err)
+		}
+	}
+	signal.Stop(sigCh)
+
+	drainTimeout := relay.Info().DrainTimeout
+	if drainTimeout == 0 {
+		drainTimeout = DefaultDrainTimeout
+	} else if drainTimeout < 0 {
+		drainTimeout = 0
+	}
+	log.Printf("received signal '%v', shutting down. drainTimeout=%vs\n", sig, drainTimeout)
+
+	// 停机期间relay拒绝新的握手，入口保持监听，管理接口与指标接口在排空过程中仍然可用
+	summary := relay.Shutdown(time.Duration(drainTimeout) * time.Second)
+	l.Close()
+
 	// 等待所有协成结束
 	wg.Wait()
-	log.Println("server stopped")
+	log.Printf("server stopped. sessions=%v streams=%v drained=%v killed=%v elapsed=%v\n",
+		summary.Sessions, summary.Streams, summary.Drained, summary.Killed,
+		summary.Elapsed.Round(time.Millisecond))
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gorilla/mux"
+	"github.com/net-agent/remotework/utils"
+)
+
+// MetricsInfo Prometheus指标接口配置
+type MetricsInfo struct {
+	Enable   bool   `json:"enable" toml:"enable"`
+	Path     string `json:"path" toml:"path"`         // 接口路径，默认为 /metrics
+	Username string `json:"username" toml:"username"` // 设置password后启用HTTP Basic Auth
+	Password string `json:"password" toml:"password"`
+}
+
+// relayStats 握手统计
+type relayStats struct {
+	success     int64 // 握手成功
+	authFailure int64 // 密码校验失败或握手数据错误
+	rejected    int64 // 校验通过但被拒绝（配额、设备绑定、domain冲突、停机）
+	refused     int64 // 握手之前被ip名单或封禁拒绝
+}
+
+// RegisterMetrics 在router上注册指标接口
+func RegisterMetrics(r *mux.Router, relay *Relay, info MetricsInfo) {
+	if info.Path == "" {
+		info.Path = "/metrics"
+	}
+
+	route := r.Methods("GET").Path(info.Path)
+	handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relay.writeMetrics(utils.NewMetricWriter(w))
+	}))
+	if info.Password != "" {
+		if info.Username == "" {
+			info.Username = "metrics"
+		}
+		handler = utils.BasicAuth(info.Username, info.Password)(handler)
+	}
+	route.Handler(handler)
+}
+
+func (relay *Relay) writeMetrics(m *utils.MetricWriter) {
+	defer m.Flush()
+
+	type domainKey struct{ tenant, domain string }
+	type nodeKey struct{ tenant, transport string }
+	nodes := make(map[nodeKey]int)
+	streams := make(map[domainKey]int)
+	for _, tenant := range relay.tenantList() {
+		for _, transport := range []string{"tcp", "ws"} {
+			nodes[nodeKey{tenant.Name, transport}] = 0
+		}
+	}
+	for _, s := range relay.Sessions() {
+		report := s.Report()
+		nodes[nodeKey{report.Tenant, report.Transport}]++
+		streams[domainKey{report.Tenant, report.Domain}] += report.Streams
+	}
+
+	m.Header("remotework_server_nodes", "gauge", "Number of connected agents.")
+	nodeKeys := make([]nodeKey, 0, len(nodes))
+	for k := range nodes {
+		nodeKeys = append(nodeKeys, k)
+	}
+	sort.Slice(nodeKeys, func(i, j int) bool {
+		if nodeKeys[i].tenant != nodeKeys[j].tenant {
+			return nodeKeys[i].tenant < nodeKeys[j].tenant
+		}
+		return nodeKeys[i].transport < nodeKeys[j].transport
+	})
+	for _, k := range nodeKeys {
+		m.Sample("remotework_server_nodes", float64(nodes[k]), "tenant", k.tenant, "transport", k.transport)
+	}
+
+	m.Header("remotework_server_handshakes_total", "counter", "Number of agent handshakes by result.")
+	m.Sample("remotework_server_handshakes_total", float64(atomic.LoadInt64(&relay.stats.success)), "result", "success")
+	m.Sample("remotework_server_handshakes_total", float64(atomic.LoadInt64(&relay.stats.authFailure)), "result", "auth_failure")
+	m.Sample("remotework_server_handshakes_total", float64(atomic.LoadInt64(&relay.stats.rejected)), "result", "rejected")
+	m.Sample("remotework_server_handshakes_total", float64(atomic.LoadInt64(&relay.stats.refused)), "result", "refused")
+
+	m.Header("remotework_server_bans", "gauge", "Number of banned source IPs.")
+	m.Sample("remotework_server_bans", float64(len(relay.guard.Bans())))
+
+	// 同一条连接在两端的domain上各计一次
+	m.Header("remotework_server_streams", "gauge", "Number of active streams per domain.")
+	streamKeys := make([]domainKey, 0, len(streams))
+	for k := range streams {
+		streamKeys = append(streamKeys, k)
+	}
+	sort.Slice(streamKeys, func(i, j int) bool {
+		if streamKeys[i].tenant != streamKeys[j].tenant {
+			return streamKeys[i].tenant < streamKeys[j].tenant
+		}
+		return streamKeys[i].domain < streamKeys[j].domain
+	})
+	for _, k := range streamKeys {
+		m.Sample("remotework_server_streams", float64(streams[k]), "tenant", k.tenant, "domain", k.domain)
+	}
+
+	// 以agent视角，in为服务端发往agent，out为agent发往服务端
+	m.Header("remotework_server_bytes_total", "counter", "Bytes relayed per domain.")
+	for _, tenant := range relay.tenantList() {
+		traffics := tenant.Traffics()
+		domains := make([]string, 0, len(traffics))
+		for domain := range traffics {
+			domains = append(domains, domain)
+		}
+		sort.Strings(domains)
+		for _, domain := range domains {
+			tr := traffics[domain]
+			m.Sample("remotework_server_bytes_total", float64(tr.In), "tenant", tenant.Name, "domain", domain, "direction", "in")
+			m.Sample("remotework_server_bytes_total", float64(tr.Out), "tenant", tenant.Name, "domain", domain, "direction", "out")
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/net-agent/remotework/utils"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	r := mux.NewRouter()
+	RegisterMetrics(r, relay, MetricsInfo{Password: "metrics-pswd"})
+
+	n := connectPipe(t, relay, "a", "pswd")
+	defer n.Close()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status without auth: %v", w.Code)
+		return
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("metrics", "metrics-pswd")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status: %v", w.Code)
+		return
+	}
+	if ct := w.Header().Get("Content-Type"); ct != utils.MetricsContentType {
+		t.Errorf("unexpected content type: '%v'", ct)
+		return
+	}
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`# HELP remotework_server_nodes Number of connected agents.`,
+		`# TYPE remotework_server_nodes gauge`,
+		`remotework_server_nodes{tenant="default",transport="pipe"} 1`,
+		`remotework_server_nodes{tenant="default",transport="tcp"} 0`,
+		`# TYPE remotework_server_handshakes_total counter`,
+		`remotework_server_handshakes_total{result="success"} 1`,
+		`remotework_server_bans 0`,
+		`remotework_server_streams{tenant="default",domain="a"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("line not found: '%v'\n%v", line, body)
+			return
+		}
+	}
+
+	// 每个样本之前都要有同名指标的TYPE
+	typed := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			typed[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+		if !typed[name] {
+			t.Errorf("sample without TYPE: '%v'", line)
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/net-agent/mixlisten"
+)
+
+const sniffTimeout = time.Second * 10
+
+// peekConn 带读缓存的连接，用于协议识别
+type peekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newPeekConn(raw net.Conn) *peekConn {
+	return &peekConn{
+		Conn:   raw,
+		reader: bufio.NewReader(raw),
+	}
+}
+
+func (conn *peekConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// onceCloser 保证协议listener只被关闭一次（mixlisten重复关闭会panic，而http.Serve退出时也会关闭listener）
+type onceCloser struct {
+	mixlisten.ProtoListener
+	once sync.Once
+}
+
+func closeOnce(l mixlisten.ProtoListener) *onceCloser {
+	return &onceCloser{ProtoListener: l}
+}
+
+func (l *onceCloser) Close() error {
+	l.once.Do(func() { l.ProtoListener.Close() })
+	return nil
+}
+
+// mixServe 与mixlisten.Run相同，根据协议特征把连接分发给各个协议的listener
+// 区别在于支持传入已有的listener，并且可以在协议识别前完成TLS握手
+// check不为空时在TLS握手之前检查来源地址，被拒绝的连接直接关闭
+// l被关闭后，等待正在识别的连接分发完毕才返回，此后可以安全地关闭各个协议的listener
+func mixServe(l net.Listener, check func(addr string) error, tlsConfig *tls.Config, allowPlain bool, protos ...mixlisten.ProtoListener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		raw, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			if err = check(raw.RemoteAddr().String()); err != nil {
+				log.Printf("conn refused, remote=%v err=%v\n", raw.RemoteAddr(), err)
+				raw.Close()
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			dispatchConn(raw, tlsConfig, allowPlain, protos)
+			wg.Done()
+		}()
+	}
+}
+
+func dispatchConn(raw net.Conn, tlsConfig *tls.Config, allowPlain bool, protos []mixlisten.ProtoListener) {
+	raw.SetDeadline(time.Now().Add(sniffTimeout))
+
+	conn := newPeekConn(raw)
+	peeked, err := conn.reader.Peek(3)
+	if err != nil {
+		raw.Close()
+		return
+	}
+
+	if tlsConfig != nil {
+		// TLS握手的第一个字节固定为0x16（record type: handshake）
+		if peeked[0] == 0x16 {
+			tc := tls.Server(conn, tlsConfig)
+			if err = tc.Handshake(); err != nil {
+				log.Printf("tls handshake failed, remote=%v err=%v\n", raw.RemoteAddr(), err)
+				raw.Close()
+				return
+			}
+			conn = newPeekConn(tc)
+			peeked, err = conn.reader.Peek(3)
+			if err != nil {
+				raw.Close()
+				return
+			}
+		} else if !allowPlain {
+			log.Printf("plain conn refused, remote=%v\n", raw.RemoteAddr())
+			raw.Close()
+			return
+		}
+	}
+	raw.SetDeadline(time.Time{})
+
+	for _, p := range protos {
+		if p.Taste(peeked) {
+			p.PushConn(conn)
+			return
+		}
+	}
+
+	log.Println("invalid protocol connected")
+	raw.Close()
+}
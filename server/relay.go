@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+// Relay 在switcher之上记录所有agent会话，为管理接口提供数据
+type Relay struct {
+	info       ServerInfo
+	configFile string // 配置文件路径，用于热加载
+	tenants    []*Tenant
+	secret     string // switcher内部使用的密码，握手校验通过后重新签名
+	guard      *Guard
+	audit      *AuditLogger // 为空时不记录审计日志
+	bindings   *Bindings    // 为空时不绑定设备
+	stats      relayStats
+
+	sessions map[int32]*Session
+	reserved map[string]bool // 已经通过admit但还没有登记会话的domain，key为 租户/domain
+	mut      sync.RWMutex
+	closing  int32
+	changed  chan struct{} // 连接或会话结束时通知，停机时据此等待排空
+}
+
+func NewRelay(info ServerInfo) (*Relay, error) {
+	secretBuf := make([]byte, 16)
+	if _, err := rand.Read(secretBuf); err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(secretBuf)
+
+	infos, err := info.TenantInfos()
+	if err != nil {
+		return nil, err
+	}
+	if err = info.checkConflict(); err != nil {
+		return nil, err
+	}
+
+	guard, err := NewGuard(info)
+	if err != nil {
+		return nil, err
+	}
+
+	var audit *AuditLogger
+	if info.Audit.Enable {
+		audit, err = NewAuditLogger(info.Audit)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log failed: %v", err)
+		}
+	}
+
+	var bindings *Bindings
+	if info.Binding.Enable {
+		bindings, err = NewBindings(info.Binding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tenants []*Tenant
+	for _, ti := range infos {
+		tenant, err := NewTenant(ti, secret)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	return &Relay{
+		info:     info,
+		tenants:  tenants,
+		secret:   secret,
+		guard:    guard,
+		audit:    audit,
+		bindings: bindings,
+		sessions: make(map[int32]*Session),
+		reserved: make(map[string]bool),
+		changed:  make(chan struct{}, 1),
+	}, nil
+}
+
+// ServeConn 读取握手包并登记会话，然后交由租户的switcher处理
+func (relay *Relay) ServeConn(pc packet.Conn, remote, transport string) {
+	pbuf, err := pc.ReadBuffer()
+	if err != nil {
+		pc.Close()
+		return
+	}
+
+	var req switcher.Request
+	err = json.Unmarshal(pbuf.Payload, &req)
+	if err != nil {
+		log.Printf("%v agent handshake failed, remote=%v err=%v\n", transport, remote, err)
+		atomic.AddInt64(&relay.stats.authFailure, 1)
+		pc.Close()
+		return
+	}
+
+	if relay.Closing() {
+		atomic.AddInt64(&relay.stats.rejected, 1)
+		rejectConn(pc, shutdownNotice)
+		return
+	}
+
+	tenant := relay.authenticate(&req)
+	if tenant == nil {
+		log.Printf("%v agent auth failed, remote=%v domain='%v'\n", transport, remote, req.Domain)
+		relay.guard.Fail(remote)
+		atomic.AddInt64(&relay.stats.authFailure, 1)
+		rejectConn(pc, "invalid checksum detected")
+		return
+	}
+	relay.guard.Success(remote)
+
+	if l := tenant.limiter(strings.ToLower(req.Domain)); l != nil && l.Rejected() {
+		log.Printf("%v agent rejected, quota exceeded. remote=%v domain='%v'\n", transport, remote, req.Domain)
+		atomic.AddInt64(&relay.stats.rejected, 1)
+		rejectConn(pc, errQuotaExceeded.Error())
+		return
+	}
+
+	req.Domain = strings.ToLower(req.Domain)
+	if err = relay.admit(tenant, &req, remote); err != nil {
+		log.Printf("%v agent rejected. remote=%v tenant='%v' domain='%v' err=%v\n",
+			transport, remote, tenant.Name, req.Domain, err)
+		atomic.AddInt64(&relay.stats.rejected, 1)
+		rejectConn(pc, err.Error())
+		return
+	}
+	atomic.AddInt64(&relay.stats.success, 1)
+
+	// 使用内部密码重新签名，交由switcher完成后续握手
+	req.Sum = req.CalcSum(relay.secret)
+	payload, err := json.Marshal(&req)
+	if err != nil {
+		relay.release(tenant, req.Domain)
+		pc.Close()
+		return
+	}
+	pbuf.SetPayload(payload)
+
+	s := newSession(relay, tenant, pc, pbuf, &req, remote, transport)
+	relay.attach(s)
+	defer relay.detach(s)
+
+	tenant.app.ServeConn(s)
+}
+
+func (relay *Relay) tenantList() []*Tenant {
+	relay.mut.RLock()
+	defer relay.mut.RUnlock()
+	return relay.tenants
+}
+
+// admit 校验设备绑定，并按照domainConflict配置处理domain已经在线的情况
+// domain预留成功后才绑定设备，被拒绝的连接不会修改绑定关系
+func (relay *Relay) admit(tenant *Tenant, req *switcher.Request, remote string) error {
+	if relay.bindings != nil {
+		if err := relay.bindings.Check(tenant.Name, req.Domain, req.Mac, remote); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range relay.Sessions() {
+		if s.tenant != tenant || s.Domain != req.Domain {
+			continue
+		}
+		if relay.Info().DomainConflict == ConflictKick {
+			log.Printf("domain replaced by new connection. tenant='%v' domain='%v' old=%v new=%v\n",
+				tenant.Name, req.Domain, s.RemoteAddr, remote)
+			s.closeWith("replaced by new connection")
+			continue
+		}
+
+		// 旧连接可能已经失效（例如agent网络切换后重连），没有回应时断开旧连接
+		if s.alive(time.Second * 3) {
+			return errDomainOnline
+		}
+		log.Printf("domain session not responding, closed. tenant='%v' domain='%v' remote=%v\n",
+			tenant.Name, req.Domain, s.RemoteAddr)
+		s.closeWith("not responding")
+	}
+	if err := relay.reserve(tenant, req.Domain); err != nil {
+		return err
+	}
+
+	if relay.bindings != nil {
+		if err := relay.bindings.Bind(tenant.Name, req.Domain, req.Mac, remote); err != nil {
+			relay.release(tenant, req.Domain)
+			return err
+		}
+	}
+	return nil
+}
+
+// reserve 在relay.mut下再次检查domain冲突并预留domain，直到会话登记
+// admit的检查过程中没有持有锁，并发的握手可能同时通过检查
+func (relay *Relay) reserve(tenant *Tenant, domain string) error {
+	relay.mut.Lock()
+	defer relay.mut.Unlock()
+
+	key := tenant.Name + "/" + domain
+	if relay.reserved[key] {
+		return errDomainOnline
+	}
+	for _, s := range relay.sessions {
+		if s.tenant == tenant && s.Domain == domain && !s.closing() {
+			return errDomainOnline
+		}
+	}
+	relay.reserved[key] = true
+	return nil
+}
+
+func (relay *Relay) release(tenant *Tenant, domain string) {
+	relay.mut.Lock()
+	delete(relay.reserved, tenant.Name+"/"+domain)
+	relay.mut.Unlock()
+}
+
+// authenticate 返回第一个校验通过的租户
+func (relay *Relay) authenticate(req *switcher.Request) *Tenant {
+	for _, tenant := range relay.tenantList() {
+		if tenant.Verify(req) {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// rejectConn 按照switcher的协议格式返回握手错误，然后关闭连接
+func rejectConn(pc packet.Conn, msg string) {
+	defer pc.Close()
+
+	var resp switcher.Response
+	resp.ErrCode = -1
+	resp.ErrMsg = msg
+	data, err := json.Marshal(&resp)
+	if err != nil {
+		return
+	}
+	pbuf := packet.NewBuffer(nil)
+	pbuf.SetPayload(data)
+	pc.WriteBuffer(pbuf)
+}
+
+func (relay *Relay) attach(s *Session) {
+	relay.mut.Lock()
+	defer relay.mut.Unlock()
+	relay.sessions[s.ID] = s
+	delete(relay.reserved, s.tenant.Name+"/"+s.Domain)
+}
+
+func (relay *Relay) detach(s *Session) {
+	relay.mut.Lock()
+	delete(relay.sessions, s.ID)
+	relay.mut.Unlock()
+
+	if s.audit != nil {
+		s.audit.closeAll(s.closeReason())
+	}
+	relay.notifyChanged()
+}
+
+// notifyChanged 通知等待中的Shutdown重新检查连接与会话，没有等待者时合并通知
+func (relay *Relay) notifyChanged() {
+	select {
+	case relay.changed <- struct{}{}:
+	default:
+	}
+}
+
+// domainByIP 根据switcher分配的ip查找租户内的domain
+func (relay *Relay) domainByIP(tenant *Tenant, ip uint16) string {
+	relay.mut.RLock()
+	defer relay.mut.RUnlock()
+
+	for _, s := range relay.sessions {
+		if s.tenant == tenant && s.Online() && s.IP() == ip {
+			return s.Domain
+		}
+	}
+	return ""
+}
+
+// allowOpen 根据租户的访问控制策略判断是否允许创建连接
+func (relay *Relay) allowOpen(s *Session, pbuf *packet.Buffer) bool {
+	policy := s.tenant.Policy()
+	if policy == nil {
+		return true
+	}
+
+	to := strings.ToLower(string(pbuf.Payload))
+	if to == "" {
+		to = relay.domainByIP(s.tenant, pbuf.DistIP())
+	}
+	if policy.Allow(s.Domain, to, pbuf.DistPort()) {
+		return true
+	}
+
+	log.Printf("dial denied by policy. tenant='%v' from='%v' to='%v' port=%v\n",
+		s.tenant.Name, s.Domain, to, pbuf.DistPort())
+	return false
+}
+
+// Tenant 根据名称查找租户
+func (relay *Relay) Tenant(name string) *Tenant {
+	for _, tenant := range relay.tenantList() {
+		if tenant.Name == name {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// Sessions 返回所有握手成功的会话，按连接时间排序
+func (relay *Relay) Sessions() []*Session {
+	relay.mut.RLock()
+	defer relay.mut.RUnlock()
+
+	var list []*Session
+	for _, s := range relay.sessions {
+		if s.Online() {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectTime.Before(list[j].ConnectTime)
+	})
+	return list
+}
+
+// Traffics 返回各租户内domain的累计流量
+func (relay *Relay) Traffics() map[string]map[string]Traffic {
+	ret := make(map[string]map[string]Traffic)
+	for _, tenant := range relay.tenantList() {
+		ret[tenant.Name] = tenant.Traffics()
+	}
+	return ret
+}
+
+// Usages 返回各租户内domain的配额使用情况
+func (relay *Relay) Usages() map[string]map[string]UsageReport {
+	ret := make(map[string]map[string]UsageReport)
+	for _, tenant := range relay.tenantList() {
+		ret[tenant.Name] = tenant.Usages()
+	}
+	return ret
+}
+
+// Kick 断开租户内domain对应的所有会话，返回断开的数量
+func (relay *Relay) Kick(tenant, domain string) int {
+	domain = strings.ToLower(domain)
+	count := 0
+	for _, s := range relay.Sessions() {
+		if s.tenant.Name == tenant && s.Domain == domain {
+			s.closeWith("kicked")
+			count++
+		}
+	}
+	if count > 0 {
+		log.Printf("domain kicked. tenant='%v' domain='%v' sessions=%v\n", tenant, domain, count)
+	}
+	return count
+}
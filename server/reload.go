@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"reflect"
+)
+
+// ReloadResult 热加载的结果
+type ReloadResult struct {
+	Updated         []string `json:"updated"`         // 更新了配置的租户
+	Added           []string `json:"added"`           // 新增的租户
+	Removed         []string `json:"removed"`         // 删除的租户，已经连接的会话不受影响
+	RestartRequired []string `json:"restartRequired"` // 有变化但需要重启才能生效的配置项
+}
+
+// Info 返回当前生效的配置
+func (relay *Relay) Info() ServerInfo {
+	relay.mut.RLock()
+	defer relay.mut.RUnlock()
+	return relay.info
+}
+
+// ReloadConfig 重新读取配置文件并应用可以在线生效的部分
+func (relay *Relay) ReloadConfig() (*ReloadResult, error) {
+	if relay.configFile == "" {
+		return nil, errors.New("config file not set")
+	}
+	cfg, err := NewConfig(relay.configFile)
+	if err != nil {
+		return nil, err
+	}
+	return relay.Reload(cfg.Server)
+}
+
+// Reload 应用新的配置：租户的密码、访问策略、带宽限制、ip名单与日志文件可以在线生效，
+// 已经连接的会话保持不变。所有配置校验通过后才会生效，校验失败时保持原有配置
+func (relay *Relay) Reload(info ServerInfo) (*ReloadResult, error) {
+	infos, err := info.TenantInfos()
+	if err != nil {
+		return nil, err
+	}
+	if err = info.checkConflict(); err != nil {
+		return nil, err
+	}
+
+	relay.mut.Lock()
+	defer relay.mut.Unlock()
+
+	existing := make(map[string]*Tenant)
+	for _, tenant := range relay.tenants {
+		existing[tenant.Name] = tenant
+	}
+
+	// 先完成所有校验，再统一生效
+	result := &ReloadResult{}
+	tenants := make([]*Tenant, 0, len(infos))
+	rules := make(map[*Tenant]*tenantRules)
+	for _, ti := range infos {
+		tenant, found := existing[ti.Name]
+		if !found {
+			tenant, err = NewTenant(ti, relay.secret)
+			if err != nil {
+				return nil, err
+			}
+			result.Added = append(result.Added, ti.Name)
+		} else {
+			r, err := loadTenantRules(ti)
+			if err != nil {
+				return nil, err
+			}
+			rules[tenant] = r
+			result.Updated = append(result.Updated, ti.Name)
+			delete(existing, ti.Name)
+		}
+		tenants = append(tenants, tenant)
+	}
+	guard, err := parseGuardRules(info)
+	if err != nil {
+		return nil, err
+	}
+	// 日志文件是最后一个可能失败的步骤，之后的修改都不会失败
+	if info.LogFile != relay.info.LogFile {
+		if err = SetLogFile(info.LogFile); err != nil {
+			return nil, err
+		}
+	} else if err = ReopenLogFile(); err != nil {
+		return nil, err
+	}
+
+	relay.guard.apply(guard)
+	for tenant, r := range rules {
+		tenant.update(r)
+	}
+	for name := range existing {
+		result.Removed = append(result.Removed, name)
+	}
+	result.RestartRequired = restartRequired(relay.info, info)
+	relay.tenants = tenants
+
+	// 需要重启的配置保持原值，避免与实际运行状态不一致
+	info.Listen = relay.info.Listen
+	info.WsEnable = relay.info.WsEnable
+	info.WsPath = relay.info.WsPath
+	info.TLS = relay.info.TLS
+	info.Admin = relay.info.Admin
+	info.Audit = relay.info.Audit
+	info.Binding = relay.info.Binding
+	info.Metrics = relay.info.Metrics
+	relay.info = info
+
+	log.Printf("config reloaded. updated=%v added=%v removed=%v\n",
+		result.Updated, result.Added, result.Removed)
+	if len(result.RestartRequired) > 0 {
+		log.Printf("config changes need restart to take effect: %v\n", result.RestartRequired)
+	}
+	return result, nil
+}
+
+// restartRequired 对比需要重启才能生效的配置项
+func restartRequired(old, cur ServerInfo) []string {
+	var fields []string
+	if old.Listen != cur.Listen {
+		fields = append(fields, "listen")
+	}
+	if old.WsEnable != cur.WsEnable {
+		fields = append(fields, "wsEnable")
+	}
+	if old.WsPath != cur.WsPath {
+		fields = append(fields, "wsPath")
+	}
+	if !reflect.DeepEqual(old.TLS, cur.TLS) {
+		fields = append(fields, "tls")
+	}
+	if old.Admin != cur.Admin {
+		fields = append(fields, "admin")
+	}
+	if old.Audit != cur.Audit {
+		fields = append(fields, "audit")
+	}
+	if old.Binding != cur.Binding {
+		fields = append(fields, "binding")
+	}
+	if old.Metrics != cur.Metrics {
+		fields = append(fields, "metrics")
+	}
+	return fields
+}
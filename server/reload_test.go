@@ -0,0 +1,80 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+func TestRelayReload(t *testing.T) {
+	info := ServerInfo{
+		Listen:   "0.0.0.0:2000",
+		Password: "old",
+		Limits:   map[string]LimitInfo{"*": {Rate: 1024}},
+	}
+	relay, err := NewRelay(info)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tenant := relay.Tenant(DefaultTenant)
+	limiter := tenant.limiter("a")
+
+	info.Listen = "0.0.0.0:3000"
+	info.Password = "new"
+	info.Limits = map[string]LimitInfo{"*": {Rate: 2048}}
+	info.Tenants = []TenantInfo{{Name: "team", Password: "team"}}
+	result, err := relay.Reload(info)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result.Added) != 1 || result.Added[0] != "team" {
+		t.Errorf("unexpected added tenants: %v", result.Added)
+	}
+	if len(result.RestartRequired) != 1 || result.RestartRequired[0] != "listen" {
+		t.Errorf("unexpected restart fields: %v", result.RestartRequired)
+	}
+	if relay.Info().Listen != "0.0.0.0:2000" {
+		t.Error("listen should not be changed before restart")
+	}
+	if relay.Tenant(DefaultTenant) != tenant {
+		t.Error("existing tenant should be kept")
+	}
+	if tenant.limiter("a") != limiter || limiter.Report().Rate != 2048 {
+		t.Error("limiter should be updated in place")
+	}
+
+	req := switcher.Request{Domain: "a", Mac: "mac", Timestamp: time.Now().UnixNano()}
+	req.Sum = req.CalcSum("old")
+	if relay.authenticate(&req) != nil {
+		t.Error("old password should be rejected")
+	}
+	req.Sum = req.CalcSum("new")
+	if relay.authenticate(&req) != tenant {
+		t.Error("new password should be accepted")
+	}
+
+	// 校验失败时保持原有配置
+	info.Tenants = []TenantInfo{{Name: "team"}, {Name: "team"}}
+	if _, err = relay.Reload(info); err == nil {
+		t.Error("duplicate tenant should be rejected")
+	}
+	if relay.Tenant("team") == nil {
+		t.Error("tenants should be kept after failed reload")
+	}
+
+	// 日志文件打开失败时，ip名单也不能生效
+	info.Tenants = nil
+	info.DenyIPs = []string{"10.0.0.0/8"}
+	info.LogFile = filepath.Join(t.TempDir(), "missing", "server.log")
+	if _, err = relay.Reload(info); err == nil {
+		t.Error("invalid log file should be rejected")
+	}
+	if err = relay.guard.Check("10.1.2.3:1234"); err != nil {
+		t.Error("deny list should not be applied after failed reload", err)
+	}
+}
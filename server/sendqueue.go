@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/net-agent/flex/v2/packet"
+)
+
+// maxQueuedBytes 单个会话下行队列允许积压的字节数
+// flex的stream自带发送窗口，正常情况下积压量不会超过 stream数量*窗口大小
+const maxQueuedBytes = 64 << 20
+
+var (
+	errSendQueueClosed   = errors.New("send queue closed")
+	errSendQueueOverflow = errors.New("send queue overflow")
+)
+
+// sendQueue 无阻塞的下行数据包队列，push不会等待写出
+type sendQueue struct {
+	bufs   []*packet.Buffer
+	size   int
+	closed bool
+	cond   *sync.Cond
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{cond: sync.NewCond(&sync.Mutex{})}
+}
+
+func (q *sendQueue) push(pbuf *packet.Buffer) error {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	if q.closed {
+		return errSendQueueClosed
+	}
+	n := packet.HeaderSz + len(pbuf.Payload)
+	if q.size+n > maxQueuedBytes {
+		return errSendQueueOverflow
+	}
+	q.bufs = append(q.bufs, pbuf)
+	q.size += n
+	q.cond.Signal()
+	return nil
+}
+
+// pop 取出队首的数据包，队列为空时等待，队列关闭后返回false
+func (q *sendQueue) pop() (*packet.Buffer, bool) {
+	q.cond.L.Lock()
+	defer q.cond.L.Unlock()
+
+	for len(q.bufs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+
+	pbuf := q.bufs[0]
+	q.bufs[0] = nil
+	q.bufs = q.bufs[1:]
+	q.size -= packet.HeaderSz + len(pbuf.Payload)
+	return pbuf, true
+}
+
+func (q *sendQueue) close() {
+	q.cond.L.Lock()
+	q.closed = true
+	q.bufs = nil
+	q.size = 0
+	q.cond.Broadcast()
+	q.cond.L.Unlock()
+}
@@ -5,18 +5,23 @@ import (
 	"net"
 
 	"github.com/net-agent/flex/v2/packet"
-	"github.com/net-agent/flex/v2/switcher"
 )
 
-func ServeTCP(app *switcher.Server, info ServerInfo, listener net.Listener) {
+func ServeTCP(relay *Relay, info ServerInfo, listener net.Listener) {
 	for {
 		c, err := listener.Accept()
 		if err != nil {
 			return
 		}
 
+		if err = relay.checkRemote(c.RemoteAddr().String()); err != nil {
+			log.Printf("tcp agent refused, remote=%v err=%v\n", c.RemoteAddr(), err)
+			c.Close()
+			continue
+		}
+
 		pc := packet.NewWithConn(c)
 		log.Printf("tcp agent connected, remote=%v\n", c.RemoteAddr())
-		go app.ServeConn(pc)
+		go relay.ServeConn(pc, c.RemoteAddr().String(), "tcp")
 	}
 }
@@ -9,18 +9,46 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"github.com/net-agent/flex/v2/packet"
-	"github.com/net-agent/flex/v2/switcher"
 )
 
-func ServeWs(app *switcher.Server, info ServerInfo, listener net.Listener) {
+func ServeWs(relay *Relay, info ServerInfo, listener net.Listener) {
 	r := mux.NewRouter()
-	r.Methods("GET").Path(info.WsPath).HandlerFunc(GetWsHandler(app))
+	r.Methods("GET").Path(info.WsPath).HandlerFunc(GetWsHandler(relay))
+	if info.Metrics.Enable {
+		RegisterMetrics(r, relay, info.Metrics)
+	}
+	if info.Admin.Enable {
+		if err := RegisterAdmin(r, relay, info.Admin); err != nil {
+			log.Printf("register admin api failed: %v\n", err)
+		}
+	}
 	http.Serve(listener, r)
 }
 
-func GetWsHandler(app *switcher.Server) http.HandlerFunc {
-	upgrader := websocket.Upgrader{}
+func GetWsHandler(relay *Relay) http.HandlerFunc {
+	upgrader := websocket.Upgrader{
+		// Origin在升级前根据wsAuth校验
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		remote := relay.guard.ClientAddr(r)
+		if err := relay.checkRemote(remote); err != nil {
+			log.Printf("ws  agent refused, remote=%v err=%v\n", remote, err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		auth := relay.Info().WsAuth
+		if !auth.checkToken(r) {
+			log.Printf("ws  agent refused, remote=%v err=invalid token\n", remote)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !auth.checkOrigin(r) {
+			log.Printf("ws  agent refused, remote=%v err=origin '%v' not allowed\n", remote, r.Header.Get("Origin"))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
 
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -30,7 +58,7 @@ func GetWsHandler(app *switcher.Server) http.HandlerFunc {
 		}
 
 		pc := packet.NewWithWs(c)
-		log.Printf("ws  agent connected, remote=%v\n", c.RemoteAddr())
-		go app.ServeConn(pc)
+		log.Printf("ws  agent connected, remote=%v\n", remote)
+		go relay.ServeConn(pc, remote, "ws")
 	}
 }
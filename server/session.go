@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v2/packet"
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+// Traffic 累计流量（以agent视角，In为下行，Out为上行）
+type Traffic struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+func (t *Traffic) Load() Traffic {
+	return Traffic{
+		In:  atomic.LoadInt64(&t.In),
+		Out: atomic.LoadInt64(&t.Out),
+	}
+}
+
+// Session 包装agent与服务端之间的packet.Conn，记录连接信息与流量
+type Session struct {
+	relay    *Relay
+	tenant   *Tenant
+	conn     packet.Conn
+	first    *packet.Buffer // 已读取的握手包，交由switcher重新读取
+	writeMut sync.Mutex
+	traffic  *Traffic
+	limiter  *Limiter      // 为空时不限速
+	sendq    *sendQueue    // 限速会话的下行队列，limiter为空时不使用
+	audit    *auditTracker // 为空时不记录审计日志
+
+	ID          int32
+	Domain      string
+	Mac         string
+	RemoteAddr  string
+	Transport   string
+	ConnectTime time.Time
+
+	online   int32
+	ip       uint32
+	streams  map[uint64]struct{}
+	strMut   sync.Mutex
+	readN    int64
+	writeN   int64
+	lastRead int64  // 最后一次读取数据包的时间（UnixNano）
+	reason   string // 会话的关闭原因
+}
+
+// SessionReport 会话信息
+type SessionReport struct {
+	ID          int32     `json:"id"`
+	Tenant      string    `json:"tenant"`
+	Domain      string    `json:"domain"`
+	Mac         string    `json:"mac"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	Transport   string    `json:"transport"`
+	ConnectTime time.Time `json:"connectTime"`
+	Streams     int       `json:"streams"`
+	In          int64     `json:"in"`
+	Out         int64     `json:"out"`
+}
+
+var sessionIndex int32
+
+var errQuotaExceeded = errors.New("traffic quota exceeded")
+
+func newSession(relay *Relay, tenant *Tenant, pc packet.Conn, first *packet.Buffer, req *switcher.Request, remote, transport string) *Session {
+	s := &Session{
+		relay:       relay,
+		tenant:      tenant,
+		conn:        pc,
+		first:       first,
+		traffic:     tenant.traffic(req.Domain),
+		limiter:     tenant.limiter(req.Domain),
+		ID:          atomic.AddInt32(&sessionIndex, 1),
+		Domain:      req.Domain,
+		Mac:         req.Mac,
+		RemoteAddr:  remote,
+		Transport:   transport,
+		ConnectTime: time.Now(),
+		streams:     make(map[uint64]struct{}),
+	}
+	if relay.audit != nil {
+		s.audit = newAuditTracker(relay.audit)
+	}
+	if s.limiter != nil {
+		s.sendq = newSendQueue()
+		go s.sendLoop()
+	}
+	return s
+}
+
+// Online 握手是否已经完成
+func (s *Session) Online() bool { return atomic.LoadInt32(&s.online) == 1 }
+
+// IP switcher为会话分配的ip
+func (s *Session) IP() uint16 { return uint16(atomic.LoadUint32(&s.ip)) }
+
+func (s *Session) Report() SessionReport {
+	s.strMut.Lock()
+	streams := len(s.streams)
+	s.strMut.Unlock()
+
+	return SessionReport{
+		ID:          s.ID,
+		Tenant:      s.tenant.Name,
+		Domain:      s.Domain,
+		Mac:         s.Mac,
+		RemoteAddr:  s.RemoteAddr,
+		Transport:   s.Transport,
+		ConnectTime: s.ConnectTime,
+		Streams:     streams,
+		In:          atomic.LoadInt64(&s.writeN),
+		Out:         atomic.LoadInt64(&s.readN),
+	}
+}
+
+// ReadBuffer 读取agent发送过来的数据包
+func (s *Session) ReadBuffer() (*packet.Buffer, error) {
+	if pbuf := s.first; pbuf != nil {
+		s.first = nil
+		return pbuf, nil
+	}
+
+	for {
+		pbuf, err := s.conn.ReadBuffer()
+		if err != nil {
+			return nil, err
+		}
+
+		atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+		n := int64(packet.HeaderSz + len(pbuf.Payload))
+		atomic.AddInt64(&s.readN, n)
+		if s.traffic != nil {
+			atomic.AddInt64(&s.traffic.Out, n)
+		}
+		// 上行限速只阻塞本会话的读取循环，控制包只计入用量，不等待令牌
+		if s.limiter != nil && s.limiter.Consume(int(n), true, isDataPacket(pbuf)) {
+			s.closeWith(errQuotaExceeded.Error())
+			return nil, errQuotaExceeded
+		}
+		if s.audit != nil {
+			s.audit.onRead(s, pbuf)
+		}
+
+		// 不允许的open请求直接回应失败，不再交给switcher
+		if pbuf.Cmd() == packet.CmdOpenStream && !s.relay.allowOpen(s, pbuf) {
+			s.WriteBuffer(pbuf.SetOpenACK("open denied by policy"))
+			continue
+		}
+
+		s.trackRead(pbuf)
+		return pbuf, nil
+	}
+}
+
+// WriteBuffer 向agent发送数据包，第一个数据包为握手的回应
+// WriteBuffer在switcher的路由循环中被其它会话调用，限速的会话只将数据包放入队列，
+// 由sendLoop按速率写出，避免一个domain的限速拖慢其它domain之间的转发
+// 心跳包与stream无关，直接写出，避免排在数据包之后导致会话被误判为失效
+func (s *Session) WriteBuffer(pbuf *packet.Buffer) error {
+	if s.sendq != nil && s.Online() && pbuf.Cmd()&^packet.CmdACKFlag != packet.CmdAlive {
+		if err := s.sendq.push(pbuf); err != nil {
+			s.closeWith(err.Error())
+			return err
+		}
+		return nil
+	}
+	return s.writeDirect(pbuf)
+}
+
+// sendLoop 按令牌桶速率写出队列中的数据包，会话关闭后退出
+func (s *Session) sendLoop() {
+	for {
+		pbuf, ok := s.sendq.pop()
+		if !ok {
+			return
+		}
+
+		n := packet.HeaderSz + len(pbuf.Payload)
+		if s.limiter.Consume(n, false, isDataPacket(pbuf)) {
+			s.closeWith(errQuotaExceeded.Error())
+			return
+		}
+		if err := s.writeDirect(pbuf); err != nil {
+			s.sendq.close()
+			return
+		}
+	}
+}
+
+// writeDirect 直接写出数据包并记录流量
+func (s *Session) writeDirect(pbuf *packet.Buffer) error {
+	s.writeMut.Lock()
+	defer s.writeMut.Unlock()
+
+	if !s.Online() {
+		var resp switcher.Response
+		if err := json.Unmarshal(pbuf.Payload, &resp); err == nil && resp.ErrCode == 0 {
+			atomic.StoreUint32(&s.ip, uint32(resp.IP))
+			atomic.StoreInt32(&s.online, 1)
+		}
+	}
+
+	n := int64(packet.HeaderSz + len(pbuf.Payload))
+	err := s.conn.WriteBuffer(pbuf)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&s.writeN, n)
+	if s.traffic != nil {
+		atomic.AddInt64(&s.traffic.In, n)
+	}
+	s.trackWrite(pbuf)
+	if s.audit != nil {
+		s.audit.onWrite(pbuf)
+	}
+
+	return nil
+}
+
+func (s *Session) Close() error {
+	if s.sendq != nil {
+		s.sendq.close()
+	}
+	return s.conn.Close()
+}
+
+// alive 向agent发送心跳包，在timeout内收到任意数据包则认为agent仍然在线
+func (s *Session) alive(timeout time.Duration) bool {
+	since := time.Now().UnixNano()
+	pbuf := packet.NewBuffer(nil)
+	pbuf.SetCmd(packet.CmdAlive)
+	pbuf.SetSrc(0xffff, 0)
+	pbuf.SetDist(s.IP(), 0)
+	if err := s.WriteBuffer(pbuf); err != nil {
+		return false
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt64(&s.lastRead) > since {
+			return true
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	return false
+}
+
+// closeWith 记录关闭原因并关闭会话，只有第一次记录的原因有效
+func (s *Session) closeWith(reason string) error {
+	s.strMut.Lock()
+	if s.reason == "" {
+		s.reason = reason
+	}
+	s.strMut.Unlock()
+	return s.Close()
+}
+
+// closing 是否已经被主动关闭
+func (s *Session) closing() bool {
+	s.strMut.Lock()
+	defer s.strMut.Unlock()
+	return s.reason != ""
+}
+
+// closeReason 返回会话的关闭原因，没有主动关闭时视为agent断开
+func (s *Session) closeReason() string {
+	s.strMut.Lock()
+	defer s.strMut.Unlock()
+	if s.reason == "" {
+		return "session closed"
+	}
+	return s.reason
+}
+
+// trackRead 跟踪agent发出的open回应与close命令
+// 流的key统一以agent视角计算：本端端口 + 对端ip + 对端端口
+func (s *Session) trackRead(pbuf *packet.Buffer) {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream | packet.CmdACKFlag:
+		// 对端发起的open请求，agent已经回应
+		if len(pbuf.Payload) == 0 {
+			s.addStream(streamKey(pbuf.SrcPort(), pbuf.DistIP(), pbuf.DistPort()))
+		}
+
+	case packet.CmdCloseStream, packet.CmdCloseStream | packet.CmdACKFlag:
+		s.delStream(streamKey(pbuf.SrcPort(), pbuf.DistIP(), pbuf.DistPort()))
+	}
+}
+
+// trackWrite 跟踪发往agent的open回应与close命令
+func (s *Session) trackWrite(pbuf *packet.Buffer) {
+	switch pbuf.Cmd() {
+	case packet.CmdOpenStream | packet.CmdACKFlag:
+		// 本端发起的open请求，对端已经回应
+		if len(pbuf.Payload) == 0 {
+			s.addStream(streamKey(pbuf.DistPort(), pbuf.SrcIP(), pbuf.SrcPort()))
+		}
+
+	case packet.CmdCloseStream, packet.CmdCloseStream | packet.CmdACKFlag:
+		s.delStream(streamKey(pbuf.DistPort(), pbuf.SrcIP(), pbuf.SrcPort()))
+	}
+}
+
+func (s *Session) addStream(key uint64) {
+	s.strMut.Lock()
+	s.streams[key] = struct{}{}
+	s.strMut.Unlock()
+}
+
+func (s *Session) delStream(key uint64) {
+	s.strMut.Lock()
+	delete(s.streams, key)
+	s.strMut.Unlock()
+	s.relay.notifyChanged()
+}
+
+// isDataPacket 是否为stream数据包，只有数据包需要等待令牌
+func isDataPacket(pbuf *packet.Buffer) bool {
+	return pbuf.Cmd() == packet.CmdPushStreamData
+}
+
+func streamKey(localPort, remoteIP, remotePort uint16) uint64 {
+	return uint64(localPort)<<32 | uint64(remoteIP)<<16 | uint64(remotePort)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/net-agent/flex/v2/packet"
+)
+
+const DefaultDrainTimeout = 30 // 秒
+
+const shutdownNotice = "server is shutting down"
+
+const sessionExitTimeout = time.Second * 5 // 断开会话后等待其退出的时长
+
+// ShutdownSummary 停机过程的统计
+type ShutdownSummary struct {
+	Sessions int           // 开始停机时在线的会话数量
+	Streams  int           // 开始停机时活跃的连接数量（连接两端的会话分别计数）
+	Drained  int           // 在超时之前自然结束的连接数量
+	Killed   int           // 超时后被强制断开的连接数量
+	Elapsed  time.Duration // 停机耗时
+}
+
+// Closing 是否正在停机
+func (relay *Relay) Closing() bool { return atomic.LoadInt32(&relay.closing) == 1 }
+
+// Shutdown 拒绝新的握手并通知所有agent，等待活跃连接结束或超时后断开全部会话
+func (relay *Relay) Shutdown(timeout time.Duration) ShutdownSummary {
+	start := time.Now()
+	atomic.StoreInt32(&relay.closing, 1)
+
+	var summary ShutdownSummary
+	sessions := relay.Sessions()
+	summary.Sessions = len(sessions)
+	summary.Streams = countStreams(sessions)
+
+	for _, s := range sessions {
+		s.Notify(shutdownNotice)
+	}
+
+	remain := summary.Streams
+	relay.waitChanged(timeout, func() bool {
+		remain = countStreams(relay.Sessions())
+		return remain == 0
+	})
+	if remain > summary.Streams {
+		remain = summary.Streams
+	}
+	summary.Killed = remain
+	summary.Drained = summary.Streams - remain
+
+	for _, s := range relay.allSessions() {
+		s.closeWith(shutdownNotice)
+	}
+	// 等待会话退出，保证审计记录完整写入
+	relay.waitChanged(sessionExitTimeout, func() bool {
+		return len(relay.allSessions()) == 0
+	})
+	if relay.audit != nil {
+		relay.audit.Close()
+	}
+	summary.Elapsed = time.Since(start)
+	return summary
+}
+
+// waitChanged 等待done成立，每次连接或会话结束时重新检查，超时返回false
+func (relay *Relay) waitChanged(timeout time.Duration, done func() bool) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for !done() {
+		select {
+		case <-relay.changed:
+		case <-timer.C:
+			return done()
+		}
+	}
+	return true
+}
+
+// allSessions 返回所有会话，包括尚未完成握手的会话
+func (relay *Relay) allSessions() []*Session {
+	relay.mut.RLock()
+	defer relay.mut.RUnlock()
+
+	list := make([]*Session, 0, len(relay.sessions))
+	for _, s := range relay.sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+func countStreams(sessions []*Session) int {
+	count := 0
+	for _, s := range sessions {
+		count += s.Report().Streams
+	}
+	return count
+}
+
+// Notify 向agent推送一条消息，旧版本的agent会忽略此类数据包
+func (s *Session) Notify(msg string) error {
+	pbuf := packet.NewBuffer(nil)
+	pbuf.SetCmd(packet.CmdPushMessage)
+	pbuf.SetPayload([]byte(msg))
+	if err := s.WriteBuffer(pbuf); err != nil {
+		log.Printf("notify session failed, domain='%v' err=%v\n", s.Domain, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/net-agent/flex/v2/node"
+)
+
+// openStream 在b上监听并由a拨号，返回a端的连接
+func openStream(t *testing.T, a, b *node.Node, port uint16) net.Conn {
+	l, err := b.Listen(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 16)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}()
+	c, err := a.DialDomain("b", port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// waitStreams 等待relay记录的连接数量达到n
+func waitStreams(relay *Relay, n int) bool {
+	deadline := time.Now().Add(time.Second)
+	for countStreams(relay.Sessions()) != n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return true
+}
+
+func TestShutdownDrain(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a := connectPipe(t, relay, "a", "pswd")
+	b := connectPipe(t, relay, "b", "pswd")
+	defer a.Close()
+	defer b.Close()
+
+	c := openStream(t, a, b, 80)
+	if !waitStreams(relay, 2) {
+		t.Error("stream not tracked")
+		return
+	}
+
+	// 连接结束后立即返回，不需要等到超时
+	time.AfterFunc(time.Millisecond*100, func() { c.Close() })
+	start := time.Now()
+	summary := relay.Shutdown(time.Second * 10)
+	if time.Since(start) > time.Second*3 {
+		t.Errorf("shutdown took too long: %v", time.Since(start))
+		return
+	}
+	if summary.Sessions != 2 || summary.Streams != 2 || summary.Drained != 2 || summary.Killed != 0 {
+		t.Errorf("unexpected summary: %+v", summary)
+		return
+	}
+	if len(relay.allSessions()) != 0 {
+		t.Error("sessions should be closed")
+		return
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	relay, err := NewRelay(ServerInfo{Password: "pswd"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	a := connectPipe(t, relay, "a", "pswd")
+	b := connectPipe(t, relay, "b", "pswd")
+	defer a.Close()
+	defer b.Close()
+
+	c := openStream(t, a, b, 80)
+	defer c.Close()
+	if !waitStreams(relay, 2) {
+		t.Error("stream not tracked")
+		return
+	}
+
+	summary := relay.Shutdown(time.Millisecond * 200)
+	if summary.Drained != 0 || summary.Killed != 2 {
+		t.Errorf("unexpected summary: %+v", summary)
+		return
+	}
+	if summary.Elapsed > time.Second*3 {
+		t.Errorf("shutdown took too long: %v", summary.Elapsed)
+		return
+	}
+	if len(relay.allSessions()) != 0 {
+		t.Error("sessions should be closed")
+		return
+	}
+	if _, err = a.DialDomain("b", 80); err == nil {
+		t.Error("dial after shutdown should fail")
+		return
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/net-agent/flex/v2/switcher"
+)
+
+const DefaultTenant = "default"
+
+// TenantInfo 租户配置，每个租户拥有独立的domain空间，租户之间无法互相访问
+type TenantInfo struct {
+	Name            string               `json:"name" toml:"name"`
+	Password        string               `json:"password" toml:"password"`               // 租户内共享的密码
+	Credentials     map[string]string    `json:"credentials" toml:"credentials"`         // domain独立密码，优先于password
+	CredentialsFile string               `json:"credentialsFile" toml:"credentialsFile"` // domain密码文件（json或toml）
+	PolicyFile      string               `json:"policyFile" toml:"policyFile"`           // 租户内的访问控制策略文件
+	Limits          map[string]LimitInfo `json:"limits" toml:"limits"`                   // domain带宽限制与配额，"*"为默认配置
+}
+
+// Tenant 租户运行时，持有独立的switcher
+type Tenant struct {
+	Name string
+	app  *switcher.Server
+	tenantRules
+
+	traffics map[string]*Traffic
+	limiters map[string]*Limiter
+	mut      sync.RWMutex
+}
+
+// tenantRules 租户内可以热加载的配置
+type tenantRules struct {
+	creds  *Credentials
+	policy *Policy // 为空时不限制domain之间的访问
+	limits map[string]LimitInfo
+}
+
+func NewTenant(info TenantInfo, secret string) (*Tenant, error) {
+	rules, err := loadTenantRules(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tenant{
+		Name:        info.Name,
+		app:         switcher.NewServer(secret),
+		tenantRules: *rules,
+		traffics:    make(map[string]*Traffic),
+		limiters:    make(map[string]*Limiter),
+	}, nil
+}
+
+func loadTenantRules(info TenantInfo) (*tenantRules, error) {
+	if info.Name == "" {
+		return nil, errors.New("tenant name is empty")
+	}
+
+	creds, err := NewCredentials(info.Password, info.Credentials, info.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("tenant '%v': %v", info.Name, err)
+	}
+
+	var policy *Policy
+	if info.PolicyFile != "" {
+		policy, err = LoadPolicy(info.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tenant '%v': load policy file failed: %v", info.Name, err)
+		}
+	}
+
+	limits := make(map[string]LimitInfo)
+	for domain, limit := range info.Limits {
+		if err = limit.check(); err != nil {
+			return nil, fmt.Errorf("tenant '%v': limit of '%v': %v", info.Name, domain, err)
+		}
+		limits[strings.ToLower(domain)] = limit
+	}
+
+	return &tenantRules{creds: creds, policy: policy, limits: limits}, nil
+}
+
+// update 替换租户的密码、策略与限制，已经存在的限速器沿用原有的用量统计
+func (t *Tenant) update(rules *tenantRules) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	t.tenantRules = *rules
+	for domain, l := range t.limiters {
+		info, _ := t.limitInfo(domain)
+		l.Update(info)
+	}
+}
+
+// Verify 使用租户的密码校验握手请求
+func (t *Tenant) Verify(req *switcher.Request) bool {
+	t.mut.RLock()
+	creds := t.creds
+	t.mut.RUnlock()
+	return creds.Verify(req)
+}
+
+// Policy 返回租户的访问控制策略，为空时不限制
+func (t *Tenant) Policy() *Policy {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+	return t.policy
+}
+
+// limitInfo 查找domain的限制配置，没有单独配置时使用"*"
+func (t *Tenant) limitInfo(domain string) (LimitInfo, bool) {
+	info, found := t.limits[domain]
+	if !found {
+		info, found = t.limits["*"]
+	}
+	return info, found
+}
+
+// traffic 获取domain的累计流量，不存在时创建
+func (t *Tenant) traffic(domain string) *Traffic {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	tr, found := t.traffics[domain]
+	if !found {
+		tr = &Traffic{}
+		t.traffics[domain] = tr
+	}
+	return tr
+}
+
+// limiter 获取domain的限速器，没有配置限制时返回nil
+func (t *Tenant) limiter(domain string) *Limiter {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if l, found := t.limiters[domain]; found {
+		return l
+	}
+
+	info, found := t.limitInfo(domain)
+	if !found {
+		return nil
+	}
+	l := NewLimiter(info)
+	t.limiters[domain] = l
+	return l
+}
+
+// Usages 返回租户内各domain的配额使用情况
+func (t *Tenant) Usages() map[string]UsageReport {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	ret := make(map[string]UsageReport)
+	for domain, l := range t.limiters {
+		ret[domain] = l.Report()
+	}
+	return ret
+}
+
+// Traffics 返回租户内各domain的累计流量
+func (t *Tenant) Traffics() map[string]Traffic {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	ret := make(map[string]Traffic)
+	for domain, tr := range t.traffics {
+		ret[domain] = tr.Load()
+	}
+	return ret
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+const (
+	defaultCertFile = "./server_cert.pem"
+	defaultKeyFile  = "./server_key.pem"
+)
+
+// TLSInfo 服务端TLS配置
+type TLSInfo struct {
+	Enable     bool     `json:"enable" toml:"enable"`
+	CertFile   string   `json:"certFile" toml:"certFile"`     // 证书文件，不存在时自动生成自签名证书
+	KeyFile    string   `json:"keyFile" toml:"keyFile"`       // 私钥文件
+	Hosts      []string `json:"hosts" toml:"hosts"`           // 自签名证书包含的域名或IP
+	AllowPlain bool     `json:"allowPlain" toml:"allowPlain"` // 是否同时接受未加密的连接
+}
+
+// NewTLSConfig 加载证书，如果证书与私钥都不存在，则生成自签名证书并保存
+func NewTLSConfig(info TLSInfo) (*tls.Config, error) {
+	certFile := info.CertFile
+	if certFile == "" {
+		certFile = defaultCertFile
+	}
+	keyFile := info.KeyFile
+	if keyFile == "" {
+		keyFile = defaultKeyFile
+	}
+
+	certExist := utils.FileExist(certFile)
+	keyExist := utils.FileExist(keyFile)
+	if certExist != keyExist {
+		return nil, errors.New("cert file and key file must both exist or both be absent")
+	}
+	if !certExist {
+		log.Printf("generate self-signed cert, cert='%v' key='%v'\n", certFile, keyFile)
+		if err := generateSelfSigned(certFile, keyFile, info.Hosts); err != nil {
+			return nil, fmt.Errorf("generate self-signed cert failed: %v", err)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("tls cert loaded, sha256 fingerprint='%v'\n", utils.CertFingerprint(cert.Certificate[0]))
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func generateSelfSigned(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"remotework"}, CommonName: "remotework server"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range append(hosts, "localhost", "127.0.0.1", "::1") {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err = ioutil.WriteFile(certFile, certPem, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, keyPem, 0600)
+}
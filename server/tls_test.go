@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/net-agent/remotework/utils"
+)
+
+func TestNewTLSConfigSelfSigned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotework-tls")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	info := TLSInfo{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		Hosts:    []string{"relay.example.com", "10.0.0.1"},
+	}
+	cfg, err := NewTLSConfig(info)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !utils.FileExist(info.CertFile) || !utils.FileExist(info.KeyFile) {
+		t.Error("self-signed cert not saved")
+		return
+	}
+
+	cert, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, host := range []string{"relay.example.com", "10.0.0.1", "localhost", "127.0.0.1"} {
+		if err = cert.VerifyHostname(host); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if cfg.MinVersion != tls.VersionTLS12 {
+		t.Error("tls1.2 required")
+		return
+	}
+
+	// 证书已经存在时直接加载，不再重新生成
+	cfg2, err := NewTLSConfig(info)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if utils.CertFingerprint(cfg2.Certificates[0].Certificate[0]) != utils.CertFingerprint(cert.Raw) {
+		t.Error("existing cert should be reused")
+		return
+	}
+
+	// 只有证书没有私钥时报错
+	os.Remove(info.KeyFile)
+	if _, err = NewTLSConfig(info); err == nil {
+		t.Error("missing key file should fail")
+		return
+	}
+}
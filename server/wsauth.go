@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WsAuthInfo websocket升级前的校验，适用于服务端部署在反向代理之后的场景
+type WsAuthInfo struct {
+	Token          string   `json:"token" toml:"token"`                   // 为空时不校验token
+	Header         string   `json:"header" toml:"header"`                 // 携带token的header，默认Authorization，此时值为"Bearer <token>"
+	AllowedOrigins []string `json:"allowedOrigins" toml:"allowedOrigins"` // 允许的Origin，"*"代表任意。为空时只允许同源请求。没有Origin的请求不受限制
+}
+
+// checkToken 校验请求携带的token
+func (info *WsAuthInfo) checkToken(r *http.Request) bool {
+	if info.Token == "" {
+		return true
+	}
+
+	var token string
+	if info.Header == "" || strings.EqualFold(info.Header, "Authorization") {
+		auth := r.Header.Get("Authorization")
+		const prefix = "Bearer "
+		if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+			return false
+		}
+		token = auth[len(prefix):]
+	} else {
+		token = r.Header.Get(info.Header)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(info.Token)) == 1
+}
+
+// checkOrigin 校验请求的Origin，agent不会发送Origin，浏览器发起的请求需要在允许列表中
+func (info *WsAuthInfo) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if len(info.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(u.Host, r.Host)
+	}
+
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range info.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
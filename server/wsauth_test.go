@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWsAuthToken(t *testing.T) {
+	cases := []struct {
+		info   WsAuthInfo
+		header string
+		value  string
+		ok     bool
+	}{
+		{WsAuthInfo{}, "", "", true},
+		{WsAuthInfo{Token: "abc"}, "", "", false},
+		{WsAuthInfo{Token: "abc"}, "Authorization", "Bearer abc", true},
+		{WsAuthInfo{Token: "abc"}, "Authorization", "bearer abc", true},
+		{WsAuthInfo{Token: "abc"}, "Authorization", "Bearer abd", false},
+		{WsAuthInfo{Token: "abc"}, "Authorization", "abc", false},
+		{WsAuthInfo{Token: "abc", Header: "X-Relay-Token"}, "X-Relay-Token", "abc", true},
+		{WsAuthInfo{Token: "abc", Header: "X-Relay-Token"}, "Authorization", "Bearer abc", false},
+	}
+	for index, c := range cases {
+		r := httptest.NewRequest("GET", "http://relay.example.com/wsconn", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		if c.info.checkToken(r) != c.ok {
+			t.Errorf("check token failed, index=%v", index)
+		}
+	}
+}
+
+func TestWsAuthOrigin(t *testing.T) {
+	cases := []struct {
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{nil, "", true},
+		{nil, "https://relay.example.com", true},
+		{nil, "https://evil.example.com", false},
+		{[]string{"https://console.example.com/"}, "https://console.example.com", true},
+		{[]string{"https://console.example.com"}, "https://relay.example.com", false},
+		{[]string{"https://console.example.com"}, "", true},
+		{[]string{"*"}, "https://evil.example.com", true},
+	}
+	for index, c := range cases {
+		info := WsAuthInfo{AllowedOrigins: c.allowed}
+		r := httptest.NewRequest("GET", "http://relay.example.com/wsconn", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if info.checkOrigin(r) != c.ok {
+			t.Errorf("check origin failed, index=%v origin=%v", index, c.origin)
+		}
+	}
+}
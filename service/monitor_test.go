@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/net-agent/remotework/agent"
+)
+
+// 多个未命名的服务各自输出一组指标，ctx结束后关闭监听
+func TestMonitorUnnamedServices(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	h := agent.NewNetHub()
+	defer h.Close()
+	h.AddServices(
+		NewPortproxy(h, "tcp://localhost:9931", echoAddr, ""),
+		NewPortproxy(h, "tcp://localhost:9932", echoAddr, ""),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- h.ServeMonitor(ctx, agent.MonitorInfo{Listen: addr}) }()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, line := range []string{
+		`remotework_agent_service_actives{service="portp",index="0"} 0`,
+		`remotework_agent_service_actives{service="portp",index="1"} 0`,
+		`remotework_agent_service_bytes_total{service="portp",index="1",direction="recv"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing line: %v", line)
+		}
+	}
+
+	// 同一组标签只能出现一次
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		series := line[:strings.LastIndex(line, " ")]
+		if seen[series] {
+			t.Errorf("duplicate series: %v", series)
+		}
+		seen[series] = true
+	}
+
+	cancel()
+	select {
+	case err = <-stopped:
+		if err != context.Canceled {
+			t.Error("unexpected error", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("monitor not stopped")
+		return
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("listener should be closed")
+	}
+}
@@ -1,13 +1,12 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/url"
-	"sync"
-	"sync/atomic"
 
 	"github.com/net-agent/remotework/agent"
 )
@@ -19,13 +18,12 @@ type Portproxy struct {
 	logName   string
 
 	enableLog bool
-	listener  net.Listener
+	slot      listenSlot
 	dialer    agent.QuickDialer
-	mut       sync.Mutex
 
 	listenNetwork string
-	actives       int32
-	dones         int32
+	stats         serviceStats
+	conns         connTracker
 }
 
 func NewPortproxy(hub *agent.NetHub, listenURL, targetURL, logName string) *Portproxy {
@@ -51,14 +49,12 @@ func (s *Portproxy) Name() string {
 func (s *Portproxy) Network() string { return s.listenNetwork }
 
 func (s *Portproxy) Report() agent.ReportInfo {
-	return agent.ReportInfo{
-		Name:    s.Name(),
-		State:   "uninit",
-		Listen:  s.listenURL,
-		Target:  s.targetURL,
-		Actives: s.actives,
-		Dones:   s.dones,
-	}
+	return s.stats.fill(agent.ReportInfo{
+		Name:   s.Name(),
+		State:  s.slot.report(s.hub, s.listenNetwork),
+		Listen: s.listenURL,
+		Target: s.targetURL,
+	})
 }
 
 func (s *Portproxy) Init() error {
@@ -73,76 +69,53 @@ func (s *Portproxy) Init() error {
 		return fmt.Errorf("parse listen url failed: %v", err)
 	}
 	s.listenNetwork = u.Scheme
-
-	if err = s.Update(); err != nil {
-		return err
-	}
-
 	s.enableLog = s.logName != ""
 
-	return nil
+	return initListen(s.hub, s.listenNetwork, s.Name(), s.Update)
 }
 
+// Update 重新监听，替换旧的监听
 func (s *Portproxy) Update() error {
-	s.mut.Lock()
-	defer s.mut.Unlock()
-
 	l, err := s.hub.ListenURL(s.listenURL)
 	if err != nil {
 		return fmt.Errorf("listen url failed: %v", err)
 	}
-
-	// close old listener
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	s.listener = l
-
-	return nil
-}
-
-func (s *Portproxy) getlistener() net.Listener {
-	s.mut.Lock()
-	defer s.mut.Unlock()
-
-	return s.listener
+	return s.slot.set(s.conns.wrap(l))
 }
 
-func (p *Portproxy) Start() error {
-	if p.dialer == nil || p.listener == nil {
+// Start 在监听上提供服务，网络没有上线时等待，直到ctx结束或者服务关闭
+func (p *Portproxy) Start(ctx context.Context) error {
+	if p.dialer == nil {
 		return errors.New("init failed")
 	}
 
-	l := p.getlistener()
-
-	for {
-		conn, err := l.Accept()
-		if err != nil {
-			if l != p.getlistener() {
-				// 如果出现错误，并且listener更新了，则切换listener，然后继续服务
-				l = p.getlistener()
-				if l != nil {
-					log.Printf("[%v] listener updated\n", p.logName)
-					continue
-				}
+	stop := closeOnDone(ctx, p)
+	defer stop()
+
+	return runSlot(ctx, &p.slot, p.Name(), func(l net.Listener) error {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
 			}
-			return err
+			go p.serve(conn)
 		}
-
-		go p.serve(conn)
-	}
+	})
 }
 
+// Close 关闭监听，并中断所有活跃的连接
 func (p *Portproxy) Close() error {
-	return p.listener.Close()
+	err := p.slot.close()
+	p.conns.abort()
+	return err
 }
 
 func (p *Portproxy) serve(c1 net.Conn) {
-	atomic.AddInt32(&p.actives, 1)
+	var sends, recvs int64
+	p.stats.open()
 	defer func() {
 		c1.Close()
-		atomic.AddInt32(&p.actives, -1)
-		atomic.AddInt32(&p.dones, 1)
+		p.stats.close(sends, recvs)
 	}()
 
 	var dialer string
@@ -154,6 +127,7 @@ func (p *Portproxy) serve(c1 net.Conn) {
 
 	c2, err := p.dialer()
 	if err != nil {
+		p.stats.dialFailed()
 		log.Printf("[%v] dial error. target=%v, err=%v\n", p.logName, p.targetURL, err)
 		return
 	}
@@ -161,5 +135,5 @@ func (p *Portproxy) serve(c1 net.Conn) {
 	if p.enableLog {
 		log.Printf("[%v] linked. %v > %v > %v\n", p.logName, dialer, p.listenURL, p.targetURL)
 	}
-	link(c1, c2)
+	sends, recvs, _ = link(c1, c2)
 }
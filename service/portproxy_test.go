@@ -2,6 +2,7 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"log"
 	"os"
@@ -28,11 +29,10 @@ func TestPortproxy(t *testing.T) {
 		t.Error("init error", err)
 		return
 	}
-	err = p.Start()
-	if err != nil {
-		t.Error("start failed", err)
-		return
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- p.Start(ctx) }()
 
 	<-time.After(time.Second)
 	conn, err := hub.DialURL(addr)
@@ -63,7 +63,20 @@ func TestPortproxy(t *testing.T) {
 		return
 	}
 
-	p.Close()
+	// 停止服务时中断活跃的连接
+	cancel()
+	select {
+	case err = <-stopped:
+		if err != context.Canceled {
+			t.Error("start failed", err)
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("stop timeout")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(buf); err == nil {
+		t.Error("link not aborted")
+	}
 }
 
 func runEchoServer(addr string) {
@@ -1,11 +1,9 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
-	"net"
-	"sync"
 
 	"github.com/net-agent/remotework/agent"
 	"github.com/net-agent/socks"
@@ -22,13 +20,12 @@ type QuickTrust struct {
 	domains map[string]string
 	logName string
 
-	users    map[string]string
-	svc      socks.Server
-	listener net.Listener
-	mut      sync.Mutex
+	users map[string]string
+	svc   socks.Server
+	slot  listenSlot
 
-	actives int32
-	dones   int32
+	stats serviceStats
+	conns connTracker
 }
 
 func NewQuickTrust(hub *agent.NetHub, network string, domains map[string]string, logName string) *QuickTrust {
@@ -48,14 +45,12 @@ func (s *QuickTrust) Name() string {
 }
 func (s *QuickTrust) Network() string { return s.network }
 func (s *QuickTrust) Report() agent.ReportInfo {
-	return agent.ReportInfo{
-		Name:    s.Name(),
-		State:   "uninit",
-		Listen:  "-",
-		Target:  "-",
-		Actives: s.actives,
-		Dones:   s.dones,
-	}
+	return s.stats.fill(agent.ReportInfo{
+		Name:   s.Name(),
+		State:  s.slot.report(s.hub, s.network),
+		Listen: "-",
+		Target: "-",
+	})
 }
 
 func (s *QuickTrust) Init() error {
@@ -64,6 +59,7 @@ func (s *QuickTrust) Init() error {
 	for k, v := range s.domains {
 		users[k+"/secret"] = v
 	}
+	s.users = users
 
 	// 构建socks5 checker
 	errAuthFailed := errors.New("auth failed")
@@ -89,57 +85,36 @@ func (s *QuickTrust) Init() error {
 	})
 	s.svc = socks.NewServer()
 	s.svc.SetAuthChecker(pswdchecker)
+	s.svc.SetRequster(s.stats.requester)
+	s.svc.SetConnLinker(s.stats.linker)
 
 	// try to listen
-	if err := s.Update(); err != nil {
-		return err
-	}
-
-	return nil
+	return initListen(s.hub, s.network, s.Name(), s.Update)
 }
 
 func (s *QuickTrust) Update() error {
-	s.mut.Lock()
-	defer s.mut.Unlock()
-
 	listenURL := fmt.Sprintf("%v://0:%v?secret=%v", s.network, QuickPort, QuickSecret)
 	l, err := s.hub.ListenURL(listenURL)
 	if err != nil {
 		return err
 	}
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	s.listener = l
-
-	return nil
+	return s.slot.set(s.conns.wrap(l))
 }
 
-func (s *QuickTrust) Start() error {
-	if s.svc == nil || s.listener == nil {
+func (s *QuickTrust) Start(ctx context.Context) error {
+	if s.svc == nil {
 		return errors.New("init failed")
 	}
-	l := s.listener
-	for {
-		err := s.svc.Run(l)
-
-		if l != s.listener && s.listener != nil {
-			log.Printf("[%v] listener updated\n", s.logName)
-			l = s.listener
-			continue
-		}
 
-		return err
-	}
+	stop := closeOnDone(ctx, s)
+	defer stop()
+
+	return runSlot(ctx, &s.slot, s.Name(), s.svc.Run)
 }
 
+// Close 关闭监听，并中断所有活跃的连接
 func (s *QuickTrust) Close() error {
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	if s.svc != nil {
-		s.svc.Close()
-	}
-
-	return nil
+	err := s.slot.close()
+	s.conns.abort()
+	return err
 }
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/net-agent/remotework/agent"
+)
+
+// registerMemtest 注册自定义scheme，使用内置的mem网络实现。scheme只能注册一次，重复运行测试时跳过
+var registerMemtest sync.Once
+
+func TestPortproxySchemes(t *testing.T) {
+	registerMemtest.Do(func() {
+		agent.RegisterScheme("memtest", func(info agent.NetworkInfo) (agent.Network, error) {
+			info.Scheme = "mem"
+			return agent.NewSchemeNetwork(info)
+		})
+	})
+
+	dir, err := ioutil.TempDir("", "remotework")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := agent.NewNetHub()
+	if err := h.AddScheme(agent.NetworkInfo{Name: "lan", Scheme: "mem"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.AddScheme(agent.NetworkInfo{Name: "bad", Scheme: "notfound"}); err == nil {
+		t.Error("unregistered scheme added")
+	}
+
+	cases := []struct {
+		listen string
+		target string
+	}{
+		{"mem://front", "mem://echo"},
+		{"lan://front", "mem://echo"},
+		{"memtest://front", "lan://echo"},
+		{"unix://" + filepath.ToSlash(filepath.Join(dir, "front.sock")), "memtest://echo"},
+	}
+	for _, c := range cases {
+		echo, err := h.ListenURL(c.target)
+		if err != nil {
+			t.Errorf("listen '%v' failed: %v", c.target, err)
+			continue
+		}
+		go func() {
+			for {
+				conn, err := echo.Accept()
+				if err != nil {
+					return
+				}
+				go io.Copy(conn, conn)
+			}
+		}()
+
+		p := NewPortproxy(h, c.listen, c.target, "")
+		if err := p.Init(); err != nil {
+			t.Errorf("init '%v' failed: %v", c.listen, err)
+			echo.Close()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go p.Start(ctx)
+
+		conn, err := h.DialURL(c.listen)
+		if err == nil {
+			payload := []byte("hello " + c.listen)
+			go conn.Write(payload)
+			buf := make([]byte, len(payload))
+			conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+			if _, err = io.ReadFull(conn, buf); err == nil && !bytes.Equal(buf, payload) {
+				t.Errorf("'%v' not equal", c.listen)
+			}
+			conn.Close()
+		}
+		if err != nil {
+			t.Errorf("'%v' -> '%v' failed: %v", c.listen, c.target, err)
+		}
+
+		cancel()
+		p.Close()
+		echo.Close()
+	}
+}
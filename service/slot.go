@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/net-agent/remotework/agent"
+)
+
+var errServiceClosed = errors.New("service closed")
+
+// listenSlot 服务当前使用的监听
+// 网络不可用时监听为空，网络上线后通过Update设置，Start在两者之间切换
+type listenSlot struct {
+	l       net.Listener
+	changed chan struct{} // 监听变化时关闭并替换
+	closed  bool
+	mut     sync.Mutex
+}
+
+// set 设置新的监听并关闭旧的监听，服务已经关闭时直接关闭新的监听
+func (s *listenSlot) set(l net.Listener) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.closed {
+		l.Close()
+		return errServiceClosed
+	}
+	if s.l != nil {
+		s.l.Close()
+	}
+	s.l = l
+	s.notify()
+	return nil
+}
+
+// reset 监听失效（例如网络断开）后清空，等待下一次set
+func (s *listenSlot) reset(l net.Listener) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.l == l {
+		s.l = nil
+		s.notify()
+	}
+}
+
+func (s *listenSlot) get() net.Listener {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.l
+}
+
+// wait 等待监听可用，ctx结束或者服务关闭时返回错误
+func (s *listenSlot) wait(ctx context.Context) (net.Listener, error) {
+	for {
+		s.mut.Lock()
+		if s.closed {
+			s.mut.Unlock()
+			return nil, errServiceClosed
+		}
+		if s.l != nil {
+			l := s.l
+			s.mut.Unlock()
+			return l, nil
+		}
+		if s.changed == nil {
+			s.changed = make(chan struct{})
+		}
+		changed := s.changed
+		s.mut.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// close 关闭监听，之后set都会失败
+func (s *listenSlot) close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var err error
+	if s.l != nil {
+		err = s.l.Close()
+		s.l = nil
+	}
+	s.closed = true
+	s.notify()
+	return err
+}
+
+func (s *listenSlot) state() string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.closed {
+		return agent.ServiceStopped
+	}
+	if s.l == nil {
+		return agent.ServiceWaiting
+	}
+	return agent.ServiceRunning
+}
+
+// report 服务状态，网络断开后监听不一定会失效，此时也视为等待
+func (s *listenSlot) report(hub *agent.NetHub, network string) string {
+	state := s.state()
+	if state == agent.ServiceRunning {
+		if online, err := hub.NetworkOnline(network); err == nil && !online {
+			return agent.ServiceWaiting
+		}
+	}
+	return state
+}
+
+func (s *listenSlot) notify() {
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+// runSlot 在当前监听上执行serve，监听被替换或失效后等待新的监听继续执行
+// 只有ctx结束或者服务关闭时才返回
+func runSlot(ctx context.Context, slot *listenSlot, logName string, serve func(l net.Listener) error) error {
+	for {
+		l, err := slot.wait(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		err = serve(l)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if slot.state() == agent.ServiceStopped {
+			return errServiceClosed
+		}
+		if slot.get() == l {
+			// 监听失效，通常是依赖的网络断开，等待网络重新上线
+			log.Printf("[%v] listener failed, waiting for network. err=%v\n", logName, err)
+			slot.reset(l)
+		} else {
+			log.Printf("[%v] listener updated\n", logName)
+		}
+	}
+}
+
+// initListen 初始化时尝试监听，依赖的网络还没有上线时进入等待状态，上线后由Update完成监听
+func initListen(hub *agent.NetHub, network, logName string, update func() error) error {
+	err := update()
+	if err == nil {
+		return nil
+	}
+	online, nerr := hub.NetworkOnline(network)
+	if nerr != nil {
+		return nerr
+	}
+	if online {
+		// 可能在两次检查之间上线，再尝试一次
+		return update()
+	}
+	log.Printf("[%v] network='%v' is not online, waiting. err=%v\n", logName, network, err)
+	return nil
+}
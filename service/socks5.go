@@ -1,12 +1,9 @@
 package service
 
 import (
+	"context"
 	"errors"
-	"io"
-	"log"
-	"net"
 	"net/url"
-	"sync/atomic"
 
 	"github.com/net-agent/remotework/agent"
 	"github.com/net-agent/socks"
@@ -19,12 +16,12 @@ type Socks5 struct {
 	password  string
 	logName   string
 
-	listener      net.Listener
+	slot          listenSlot
 	listenNetwork string
 	server        socks.Server
 
-	actives int32
-	dones   int32
+	stats serviceStats
+	conns connTracker
 }
 
 func NewSocks5(hub *agent.NetHub, listenURL, username, password, logName string) *Socks5 {
@@ -46,26 +43,18 @@ func (s *Socks5) Name() string {
 func (s *Socks5) Network() string { return s.listenNetwork }
 
 func (s *Socks5) Report() agent.ReportInfo {
-	return agent.ReportInfo{
-		Name:    s.Name(),
-		State:   "uninit",
-		Listen:  s.listenURL,
-		Target:  "-",
-		Actives: s.actives,
-		Dones:   s.dones,
-	}
+	return s.stats.fill(agent.ReportInfo{
+		Name:   s.Name(),
+		State:  s.slot.report(s.hub, s.listenNetwork),
+		Listen: s.listenURL,
+		Target: "-",
+	})
 }
 
 func (s *Socks5) Init() error {
 	s.server = socks.NewPswdServer(s.username, s.password)
-	s.server.SetConnLinker(func(a, b io.ReadWriteCloser) (a2b int64, b2a int64, err error) {
-		atomic.AddInt32(&s.actives, 1)
-		defer func() {
-			atomic.AddInt32(&s.actives, -1)
-			atomic.AddInt32(&s.dones, 1)
-		}()
-		return link(a, b)
-	})
+	s.server.SetRequster(s.stats.requester)
+	s.server.SetConnLinker(s.stats.linker)
 
 	u, err := url.Parse(s.listenURL)
 	if err != nil {
@@ -73,11 +62,7 @@ func (s *Socks5) Init() error {
 	}
 	s.listenNetwork = u.Scheme
 
-	if err := s.Update(); err != nil {
-		return err
-	}
-
-	return nil
+	return initListen(s.hub, s.listenNetwork, s.Name(), s.Update)
 }
 
 func (s *Socks5) Update() error {
@@ -85,39 +70,24 @@ func (s *Socks5) Update() error {
 	if err != nil {
 		return err
 	}
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	s.listener = l
-
-	return nil
+	return s.slot.set(s.conns.wrap(l))
 }
 
-func (s *Socks5) Start() error {
-	if s.server == nil || s.listener == nil {
+func (s *Socks5) Start(ctx context.Context) error {
+	if s.server == nil {
 		return errors.New("init failed")
 	}
 
-	l := s.listener
-	for {
-		err := s.server.Run(l)
-
-		if l != s.listener && s.listener != nil {
-			log.Printf("[%v] listener updated\n", s.logName)
-			l = s.listener
-			continue
-		}
+	stop := closeOnDone(ctx, s)
+	defer stop()
 
-		return err
-	}
+	return runSlot(ctx, &s.slot, s.Name(), s.server.Run)
 }
 
+// Close 关闭监听，并中断所有活跃的连接
+// socks.Server.Close会等待连接自然结束，这里先中断连接再等待处理协程退出
 func (s *Socks5) Close() error {
-	if s.listener != nil {
-		s.listener.Close()
-	}
-	if s.server != nil {
-		s.server.Close()
-	}
-	return nil
+	err := s.slot.close()
+	s.conns.abort()
+	return err
 }
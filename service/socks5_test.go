@@ -0,0 +1,74 @@
+package service
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/net-agent/remotework/utils"
+	"github.com/net-agent/socks"
+)
+
+// socksDial 能连接IPv4、IPv6地址与域名，目标监听在双栈地址上
+func TestSocksDialIPv6(t *testing.T) {
+	target, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer target.Close()
+	go func() {
+		for {
+			c, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	port := strconv.Itoa(target.Addr().(*net.TCPAddr).Port)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	svc := socks.NewServer()
+	svc.SetRequster(func(req socks.Request, ctx socks.Context) (net.Conn, error) { return socksDial(req) })
+	go svc.Run(l)
+	defer svc.Close()
+
+	addrs := []string{"127.0.0.1:" + port, "localhost:" + port}
+	if c, err := net.Dial("tcp6", "[::1]:"+port); err == nil {
+		c.Close()
+		addrs = append(addrs, "[::1]:"+port)
+	} else {
+		t.Log("ipv6 loopback not available, skip [::1]")
+	}
+
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err = utils.SocksConnect(conn, nil, addr); err != nil {
+			t.Error(addr, err)
+			conn.Close()
+			continue
+		}
+		payload := []byte("hello " + addr)
+		buf := make([]byte, len(payload))
+		if _, err = conn.Write(payload); err == nil {
+			_, err = io.ReadFull(conn, buf)
+		}
+		if err != nil || string(buf) != string(payload) {
+			t.Error(addr, "echo failed", err)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/net-agent/remotework/agent"
+	"github.com/net-agent/socks"
+)
+
+// serviceStats 服务的连接统计
+type serviceStats struct {
+	actives    int32
+	dones      int32
+	dialErrors int32
+	sends      int64 // 发往目标的字节数
+	recvs      int64 // 从目标收到的字节数
+}
+
+func (st *serviceStats) open() {
+	atomic.AddInt32(&st.actives, 1)
+}
+
+func (st *serviceStats) close(sends, recvs int64) {
+	atomic.AddInt64(&st.sends, sends)
+	atomic.AddInt64(&st.recvs, recvs)
+	atomic.AddInt32(&st.actives, -1)
+	atomic.AddInt32(&st.dones, 1)
+}
+
+func (st *serviceStats) dialFailed() {
+	atomic.AddInt32(&st.dialErrors, 1)
+}
+
+// linker 作为socks.Server的ConnLinker，a为客户端连接，b为目标连接
+func (st *serviceStats) linker(a, b io.ReadWriteCloser) (a2b int64, b2a int64, err error) {
+	st.open()
+	defer func() { st.close(a2b, b2a) }()
+	return link(a, b)
+}
+
+// requester 作为socks.Server的Requester，记录创建连接失败的次数
+func (st *serviceStats) requester(req socks.Request, ctx socks.Context) (net.Conn, error) {
+	conn, err := socksDial(req)
+	if err != nil {
+		st.dialFailed()
+	}
+	return conn, err
+}
+
+// fill 将统计数据写入report
+func (st *serviceStats) fill(info agent.ReportInfo) agent.ReportInfo {
+	info.Actives = atomic.LoadInt32(&st.actives)
+	info.Dones = atomic.LoadInt32(&st.dones)
+	info.DialErrors = atomic.LoadInt32(&st.dialErrors)
+	info.Sends = atomic.LoadInt64(&st.sends)
+	info.Recvs = atomic.LoadInt64(&st.recvs)
+	return info
+}
+
+// socksDial 连接socks请求的目标。socks.DefaultRequester只支持tcp4，且无法拼接IPv6地址
+func socksDial(req socks.Request) (net.Conn, error) {
+	if req.GetCommand() != socks.ConnectCommand {
+		return nil, socks.ErrReplyCmdNotSupported
+	}
+	atyp, buf := req.GetAddress()
+	host := string(buf)
+	if atyp == socks.IPv4 || atyp == socks.IPv6 {
+		host = net.IP(buf).String()
+	}
+	return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(req.GetPort()))))
+}
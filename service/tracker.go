@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// connTracker 记录服务接受的连接，停止服务时统一关闭并等待处理结束
+type connTracker struct {
+	conns map[*trackedConn]struct{}
+	wg    sync.WaitGroup
+	mut   sync.Mutex
+}
+
+// wrap 包装listener，记录accept得到的连接
+func (t *connTracker) wrap(l net.Listener) net.Listener {
+	return &trackedListener{Listener: l, tracker: t}
+}
+
+func (t *connTracker) add(c *trackedConn) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[*trackedConn]struct{})
+	}
+	t.conns[c] = struct{}{}
+	t.wg.Add(1)
+}
+
+func (t *connTracker) remove(c *trackedConn) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if _, found := t.conns[c]; found {
+		delete(t.conns, c)
+		t.wg.Done()
+	}
+}
+
+// abort 关闭所有活跃的连接，并等待连接的处理协程退出
+// 连接关闭后link会关闭另一端的连接
+func (t *connTracker) abort() {
+	t.mut.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mut.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	t.wg.Wait()
+}
+
+type trackedListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &trackedConn{Conn: conn, tracker: l.tracker}
+	l.tracker.add(c)
+	if d, ok := conn.(interface{ Dialer() string }); ok {
+		// quicktrust与portproxy依赖Dialer获取来源
+		return &trackedDialerConn{trackedConn: c, dialer: d}, nil
+	}
+	return c, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.tracker.remove(c) })
+	return err
+}
+
+type trackedDialerConn struct {
+	*trackedConn
+	dialer interface{ Dialer() string }
+}
+
+func (c *trackedDialerConn) Dialer() string {
+	return c.dialer.Dialer()
+}
+
+// closeOnDone ctx结束时关闭服务。调用返回的函数停止等待，如果正在关闭则等待关闭完成
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
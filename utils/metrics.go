@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricWriter 按照Prometheus文本格式输出指标
+type MetricWriter struct {
+	w *bufio.Writer
+}
+
+func NewMetricWriter(w http.ResponseWriter) *MetricWriter {
+	w.Header().Set("Content-Type", MetricsContentType)
+	return &MetricWriter{w: bufio.NewWriter(w)}
+}
+
+// Header 输出指标的说明与类型（counter、gauge）
+func (m *MetricWriter) Header(name, typ, help string) {
+	m.w.WriteString("# HELP " + name + " " + help + "\n")
+	m.w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+// Sample 输出一个样本，labels按照 key, value 成对传入
+func (m *MetricWriter) Sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) >= 2 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			m.w.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.w.WriteByte('\n')
+}
+
+func (m *MetricWriter) Flush() error {
+	return m.w.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
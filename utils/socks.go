@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/net-agent/socks"
+)
+
+var socksReplyErrs = []error{
+	nil,
+	socks.ErrReplyFailure,
+	socks.ErrReplyConnectionNotAllow,
+	socks.ErrReplyNetworkUnRereachable,
+	socks.ErrReplyHostUnreachable,
+	socks.ErrReplyConnectionRefused,
+	socks.ErrReplyTTLExpired,
+	socks.ErrReplyCmdNotSupported,
+	socks.ErrReplyAtypeNotSupported,
+}
+
+// SocksConnect 在conn上完成socks5握手并请求连接addr，auth为空时不需要认证
+// 与socks.ProxyInfo.Upgrade不同，addr支持IPv6地址（[::1]:3389）
+// ip地址按ip类型发送，域名由对端解析
+func SocksConnect(conn net.Conn, auth socks.Auth, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port '%v'", portStr)
+	}
+	if len(host) > 255 {
+		return socks.ErrAddressBufTooLong
+	}
+
+	if auth == nil {
+		auth = socks.NoAuth()
+	}
+	wt, next, err := auth.Start()
+	if err != nil {
+		return err
+	}
+	for next {
+		if _, err = wt.WriteTo(conn); err != nil {
+			return err
+		}
+		if wt, next, err = auth.Next(conn); err != nil {
+			return err
+		}
+	}
+
+	req := []byte{socks.VersionSocks5, socks.ConnectCommand, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(req, socks.Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks.IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks.IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	// 回应：ver rep rsv atyp addr port
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	var addrLen int
+	switch head[3] {
+	case socks.IPv4:
+		addrLen = net.IPv4len
+	case socks.IPv6:
+		addrLen = net.IPv6len
+	case socks.Domain:
+		size := make([]byte, 1)
+		if _, err = io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		addrLen = int(size[0])
+	default:
+		return socks.ErrAddressTypeNotSupport
+	}
+	// 绑定地址与端口用不到，读出后丢弃
+	bound := make([]byte, addrLen+2)
+	if _, err = io.ReadFull(conn, bound); err != nil {
+		return err
+	}
+
+	rep := int(head[1])
+	if rep == 0 {
+		return nil
+	}
+	if rep < len(socksReplyErrs) {
+		return socksReplyErrs[rep]
+	}
+	return errors.New("connect failed with code: " + strconv.Itoa(rep))
+}
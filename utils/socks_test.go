@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/net-agent/socks"
+)
+
+// readSocksRequest 模拟socks5服务端，读取握手与连接请求后按rep回应
+func readSocksRequest(c net.Conn, rep byte) ([]byte, error) {
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return nil, err
+	}
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(c, req); err != nil {
+		return nil, err
+	}
+	size := map[byte]int{socks.IPv4: net.IPv4len, socks.IPv6: net.IPv6len}[req[3]]
+	if req[3] == socks.Domain {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return nil, err
+		}
+		req = append(req, b[0])
+		size = int(b[0])
+	}
+	addr := make([]byte, size+2)
+	if _, err := io.ReadFull(c, addr); err != nil {
+		return nil, err
+	}
+	_, err := c.Write([]byte{5, rep, 0, socks.IPv4, 0, 0, 0, 0, 0, 0})
+	return append(req, addr...), err
+}
+
+func TestSocksConnect(t *testing.T) {
+	cases := []struct {
+		addr   string
+		expect []byte
+	}{
+		{"[::1]:3389", append([]byte{5, 1, 0, socks.IPv6}, append(net.ParseIP("::1"), 0x0d, 0x3d)...)},
+		{"[fe80::1%eth0]:80", nil},
+		{"127.0.0.1:80", []byte{5, 1, 0, socks.IPv4, 127, 0, 0, 1, 0, 80}},
+		{"example.com:80", append([]byte{5, 1, 0, socks.Domain, 11}, append([]byte("example.com"), 0, 80)...)},
+	}
+	for _, c := range cases {
+		c1, c2 := net.Pipe()
+		reqs := make(chan []byte, 1)
+		go func() {
+			req, _ := readSocksRequest(c2, 0)
+			reqs <- req
+			c2.Close()
+		}()
+		err := SocksConnect(c1, nil, c.addr)
+		c1.Close()
+		req := <-reqs
+		if c.expect == nil {
+			// 带zone的IPv6地址无法按ip发送，作为域名交给对端
+			if err != nil || req[3] != socks.Domain {
+				t.Errorf("%v: unexpected request %v %v", c.addr, req, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(c.addr, err)
+			continue
+		}
+		if !bytes.Equal(req, c.expect) {
+			t.Errorf("%v: unexpected request %v", c.addr, req)
+		}
+	}
+
+	// 对端返回的错误码转换为socks包中的错误
+	c1, c2 := net.Pipe()
+	go func() {
+		readSocksRequest(c2, 5)
+		c2.Close()
+	}()
+	if err := SocksConnect(c1, nil, "[::1]:80"); err != socks.ErrReplyConnectionRefused {
+		t.Error("unexpected error", err)
+	}
+	c1.Close()
+
+	if err := SocksConnect(c1, nil, "::1:80"); err == nil {
+		t.Error("ipv6 address without brackets should fail")
+	}
+}
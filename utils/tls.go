@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// CertFingerprint 计算证书（DER格式）的sha256指纹，返回小写hex
+func CertFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint 去掉指纹中的冒号与空格，并转换为小写
+func NormalizeFingerprint(fp string) string {
+	fp = strings.ReplaceAll(fp, ":", "")
+	fp = strings.ReplaceAll(fp, " ", "")
+	return strings.ToLower(fp)
+}
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"log"
@@ -41,3 +43,10 @@ func FileExist(path string) bool {
 	log.Fatal("load file error: ", err)
 	return false
 }
+
+// HashPassword 计算密码摘要，格式为 "sha256:<hex>"
+// agent使用摘要代替明文进行握手，服务端保存的摘要与明文密码同样敏感，只起到隐藏原始密码的作用
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}